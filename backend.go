@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// backendCollectorName is the collector of the backend series in the series limits
+	backendCollectorName  = "backend"
+	defaultBackendDBName  = "postgres"
+	defaultBackendTimeout = 5 * time.Second
+	// backendLocalAddr matches the connections over a unix socket, which have no client address
+	backendLocalAddr = "local"
+)
+
+// serverConnectionColumns are the POOLS columns of server connections open on the PostgreSQL server
+var serverConnectionColumns = []string{"sv_active", "sv_idle", "sv_used", "sv_tested", "sv_login"}
+
+const backendActivityQuery = `SELECT coalesce(datname, ''), coalesce(usename, ''), coalesce(state, ''), count(*)
+FROM pg_stat_activity
+WHERE backend_type = 'client backend' AND pid <> pg_backend_pid()
+  AND ($1::text[] IS NULL OR host(client_addr) = ANY($1) OR client_addr IS NULL AND $2)
+GROUP BY 1, 2, 3`
+
+const backendServerQuery = `SELECT current_setting('max_connections')::int,
+  current_setting('superuser_reserved_connections')::int,
+  (SELECT count(*) FROM pg_stat_activity WHERE backend_type = 'client backend')`
+
+// BackendConfig connects to the PostgreSQL servers of SHOW DATABASES, an empty user disables it
+type BackendConfig struct {
+	User         string `yaml:"user"`
+	PasswordFile string `yaml:"password_file"`
+	PassFile     string `yaml:"passfile"`
+	DBName       string `yaml:"dbname"`
+	SSLMode      string `yaml:"sslmode"`
+	// ClientAddrs are the addresses of PgBouncer seen by the servers, "local" for unix sockets,
+	// empty counts all client connections but the exporter's own and exports no unaccounted connections
+	ClientAddrs []string `yaml:"client_addrs"`
+	// Timeout bounds the queries of all servers of a scrape, keep it below the scrape timeout
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (b BackendConfig) enabled() bool {
+	return len(b.User) != 0
+}
+
+func (b BackendConfig) validate() error {
+	for _, addr := range b.ClientAddrs {
+		if addr != backendLocalAddr && net.ParseIP(addr) == nil {
+			return fmt.Errorf("backend: invalid client address %q, expected an IP address or local", addr)
+		}
+	}
+	return nil
+}
+
+// backendKey is a PostgreSQL server of SHOW DATABASES
+type backendKey struct {
+	host string
+	port string
+}
+
+// backendPoolKey is a database and login user on a PostgreSQL server
+type backendPoolKey struct {
+	backendKey
+	database string
+	user     string
+}
+
+// backendCollector counts the server connections in pg_stat_activity of every PostgreSQL server
+// and compares them with the server connections of the PgBouncer pools
+type backendCollector struct {
+	config  BackendConfig
+	logger  *slog.Logger
+	servers *MetricGroup
+	pools   *MetricGroup
+	states  *MetricGroup
+
+	dbs map[backendKey]*sql.DB
+}
+
+func newBackendCollector(config BackendConfig, logger *slog.Logger, filters []*LabelFilter) *backendCollector {
+	if len(config.DBName) == 0 {
+		config.DBName = defaultBackendDBName
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultBackendTimeout
+	}
+	if len(config.ClientAddrs) == 0 {
+		logger.Warn("Backend client_addrs are not set, unaccounted connections are not exported")
+	}
+	return &backendCollector{
+		config:  config,
+		logger:  logger,
+		servers: buildMetricGroup(MetricDescriptorBackendServers, nil),
+		pools:   attachFilters(buildMetricGroup(MetricDescriptorBackendPools, nil), filters),
+		states:  attachFilters(buildMetricGroup(MetricDescriptorBackendStates, nil), filters),
+		dbs:     make(map[backendKey]*sql.DB),
+	}
+}
+
+// metricGroups returns the groups of all metrics the collector produces
+func (b *backendCollector) metricGroups() []*MetricGroup {
+	return []*MetricGroup{b.servers, b.pools, b.states}
+}
+
+// backendResult is the outcome of the query of a server
+type backendResult struct {
+	server  backendKey
+	counts  map[backendPoolKey]float64
+	metrics []prometheus.Metric
+	err     error
+}
+
+// metrics queries the servers of the DATABASES rows concurrently within one timeout
+// and returns the metrics and the number of failed servers
+func (b *backendCollector) metrics(pools, databases []Row) ([]prometheus.Metric, int) {
+	var metrics []prometheus.Metric
+	emit := func(metricGroup *MetricGroup, name string, value float64, labelValues ...string) {
+		metrics = appendMetric(metrics, metricGroup, name, value, labelValues...)
+	}
+
+	pooler := poolerConnections(pools, databases)
+	servers := make(map[backendKey]bool)
+	for key := range pooler {
+		servers[key.backendKey] = true
+	}
+	// Servers of databases without pools have no PgBouncer connections
+	for _, row := range databases {
+		if key, ok := databaseBackend(row); ok {
+			servers[key] = true
+		}
+	}
+
+	// One deadline for all servers keeps the scrape below its timeout however many servers are slow
+	ctx, cancel := context.WithTimeout(context.Background(), b.config.Timeout)
+	defer cancel()
+	results := make(chan backendResult, len(servers))
+	for server := range servers {
+		db, ok := b.dbs[server]
+		if !ok {
+			db = sql.OpenDB(&backendConnector{config: b.config, server: server})
+			db.SetMaxOpenConns(1)
+			db.SetMaxIdleConns(1)
+			b.dbs[server] = db
+		}
+		go func(server backendKey, db *sql.DB) {
+			result := backendResult{server: server}
+			result.counts, result.metrics, result.err = b.query(ctx, db, server)
+			results <- result
+		}(server, db)
+	}
+
+	failed := 0
+	up := make(map[backendKey]bool)
+	backend := make(map[backendPoolKey]float64)
+	for range servers {
+		result := <-results
+		server := result.server
+		if result.err != nil {
+			b.logger.Error("Failed to query backend server", "host", server.host, "port", server.port, "err", result.err)
+			emit(b.servers, "up", 0, server.host, server.port)
+			failed++
+			continue
+		}
+		up[server] = true
+		emit(b.servers, "up", 1, server.host, server.port)
+		metrics = append(metrics, result.metrics...)
+		for key, count := range result.counts {
+			backend[key] += count
+		}
+	}
+
+	metrics = append(metrics, b.poolMetrics(pooler, backend, up)...)
+
+	// Connections of servers that are gone from DATABASES are closed
+	for key, db := range b.dbs {
+		if !servers[key] {
+			db.Close()
+			delete(b.dbs, key)
+		}
+	}
+	return metrics, failed
+}
+
+// poolMetrics compares the PgBouncer server connections with the connections on the servers that are up.
+// Unaccounted connections are open on the server but not in a pool, or the other way round, without the
+// addresses of the bouncer the connections of the applications would count as leaked
+func (b *backendCollector) poolMetrics(pooler, backend map[backendPoolKey]float64, up map[backendKey]bool) []prometheus.Metric {
+	accounted := len(b.config.ClientAddrs) != 0
+	keys := make(map[backendPoolKey]bool)
+	for key := range backend {
+		keys[key] = true
+	}
+	for key := range pooler {
+		if up[key.backendKey] {
+			keys[key] = true
+		}
+	}
+	var metrics []prometheus.Metric
+	for key := range keys {
+		labelValues := []string{key.host, key.port, key.database, key.user}
+		if !matchRowFilters(b.pools, labelValues) {
+			continue
+		}
+		metrics = appendMetric(metrics, b.pools, "pooler_connections", pooler[key], labelValues...)
+		if accounted {
+			metrics = appendMetric(metrics, b.pools, "unaccounted_connections", backend[key]-pooler[key], labelValues...)
+		}
+	}
+	return metrics
+}
+
+// appendMetric appends a metric of the group if the group has it
+func appendMetric(metrics []prometheus.Metric, metricGroup *MetricGroup, name string, value float64, labelValues ...string) []prometheus.Metric {
+	if metricDesc := metricGroup.Metrics[name]; metricDesc != nil {
+		metrics = append(metrics, prometheus.MustNewConstMetric(&metricDesc.Desc, metricDesc.Type, value, labelValues...))
+	}
+	return metrics
+}
+
+// query reads pg_stat_activity of a server and returns the connections from PgBouncer by database and user
+// with the metrics of the server
+func (b *backendCollector) query(ctx context.Context, db *sql.DB, server backendKey) (map[backendPoolKey]float64, []prometheus.Metric, error) {
+	var maxConnections, reserved, connections float64
+	if err := db.QueryRowContext(ctx, backendServerQuery).Scan(&maxConnections, &reserved, &connections); err != nil {
+		return nil, nil, err
+	}
+
+	var addrs interface{}
+	local := len(b.config.ClientAddrs) == 0
+	if !local {
+		// An empty array matches no address, NULL would match all
+		hosts := []string{}
+		for _, addr := range b.config.ClientAddrs {
+			if addr == backendLocalAddr {
+				local = true
+			} else {
+				hosts = append(hosts, addr)
+			}
+		}
+		addrs = pq.Array(hosts)
+	}
+	rows, err := db.QueryContext(ctx, backendActivityQuery, addrs, local)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var metrics []prometheus.Metric
+	counts := make(map[backendPoolKey]float64)
+	for rows.Next() {
+		var database, user, state string
+		var count float64
+		if err = rows.Scan(&database, &user, &state, &count); err != nil {
+			return nil, nil, err
+		}
+		key := backendPoolKey{backendKey: server, database: database, user: user}
+		counts[key] += count
+		labelValues := []string{server.host, server.port, database, user, state}
+		if matchRowFilters(b.states, labelValues) {
+			metrics = appendMetric(metrics, b.states, "connections", count, labelValues...)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	metrics = appendMetric(metrics, b.servers, "max_connections", maxConnections, server.host, server.port)
+	metrics = appendMetric(metrics, b.servers, "server_connections", connections, server.host, server.port)
+	metrics = appendMetric(metrics, b.servers, "server_headroom", maxConnections-reserved-connections, server.host, server.port)
+	return counts, metrics, nil
+}
+
+// Close closes the connections to the servers
+func (b *backendCollector) Close() {
+	for key, db := range b.dbs {
+		db.Close()
+		delete(b.dbs, key)
+	}
+}
+
+// poolerConnections sums the server connections of the POOLS rows by server, database and login user,
+// a database with force_user logs in as that user
+func poolerConnections(pools, databases []Row) map[backendPoolKey]float64 {
+	databaseRows := make(map[string]Row)
+	for _, row := range databases {
+		databaseRows[cast2string(row["name"])] = row
+	}
+	connections := make(map[backendPoolKey]float64)
+	for _, row := range pools {
+		databaseRow, ok := databaseRows[cast2string(row["database"])]
+		if !ok {
+			continue
+		}
+		server, ok := databaseBackend(databaseRow)
+		if !ok {
+			continue
+		}
+		user := cast2string(databaseRow["force_user"])
+		if len(user) == 0 {
+			user = cast2string(row["user"])
+		}
+		key := backendPoolKey{backendKey: server, database: cast2string(databaseRow["database"]), user: user}
+		for _, column := range serverConnectionColumns {
+			if v := cast2Float64(row[column], 1); v > 0 {
+				connections[key] += v
+			}
+		}
+	}
+	return connections
+}
+
+// databaseBackend returns the server of a DATABASES row, the admin console and
+// databases with several hosts are left out
+func databaseBackend(row Row) (backendKey, bool) {
+	host := cast2string(row["host"])
+	if len(host) == 0 || strings.Contains(host, ",") || cast2string(row["name"]) == "pgbouncer" {
+		return backendKey{}, false
+	}
+	port := cast2string(row["port"])
+	if len(port) == 0 || port == "0" {
+		port = "5432"
+	}
+	return backendKey{host: host, port: port}, true
+}
+
+// backendConnector builds the connection string of a server on every connect,
+// so a rotated password file is picked up on reconnect
+type backendConnector struct {
+	config BackendConfig
+	server backendKey
+}
+
+func (c *backendConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	opts := map[string]string{
+		"host":             c.server.host,
+		"port":             c.server.port,
+		"user":             c.config.User,
+		"dbname":           c.config.DBName,
+		"application_name": "pgbouncer-exporter",
+		"connect_timeout":  strconv.Itoa(int(math.Ceil(c.config.Timeout.Seconds()))),
+	}
+	if len(c.config.SSLMode) != 0 {
+		opts["sslmode"] = c.config.SSLMode
+	}
+	if len(c.config.PasswordFile) != 0 {
+		content, err := os.ReadFile(c.config.PasswordFile)
+		if err != nil {
+			return nil, err
+		}
+		opts["password"] = strings.TrimRight(string(content), "\r\n")
+	} else {
+		password, err := lookupPassFile(defaultPassFile(c.config.PassFile), opts)
+		if err != nil {
+			return nil, err
+		}
+		if len(password) != 0 {
+			opts["password"] = password
+		}
+	}
+
+	dsn := formatDSN(opts)
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		return nil, redactError(err, dsn)
+	}
+	conn, err := connector.Connect(ctx)
+	if err != nil {
+		return nil, redactError(err, dsn)
+	}
+	return conn, nil
+}
+
+func (c *backendConnector) Driver() driver.Driver {
+	return &pq.Driver{}
+}
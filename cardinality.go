@@ -0,0 +1,291 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	overflowAggregate = "aggregate"
+	overflowDrop      = "drop"
+	overflowLabel     = "other"
+
+	aggregateSum  = ""
+	aggregateMax  = "max"
+	aggregateNone = "none"
+)
+
+// seriesGuard limits the series emitted by a target per scrape. The series of a row, keyed by its
+// database or else by all its labels, are kept or overflow together, and the rows kept in the
+// previous scrape are kept first, so the kept set only changes when rows come and go.
+type seriesGuard struct {
+	limits  LimitsConfig
+	dropped *prometheus.CounterVec
+	// row keys kept in the previous scrape by collector, or the series of the previous scrape
+	// if all of them were kept, their keys are only computed once a collector overflows
+	kept    map[string]map[string]bool
+	keptAll map[string][]prometheus.Metric
+
+	// state of the current scrape
+	total int
+	// databases over the limits of a collector, their rows overflow in the collectors applied later
+	overflowDatabases map[string]bool
+}
+
+// guardRow holds the series of a row in emission order
+type guardRow struct {
+	database string
+	metrics  []prometheus.Metric
+}
+
+func newSeriesGuard(limits LimitsConfig, dropped *prometheus.CounterVec) *seriesGuard {
+	return &seriesGuard{
+		limits:            limits,
+		dropped:           dropped,
+		kept:              make(map[string]map[string]bool),
+		keptAll:           make(map[string][]prometheus.Metric),
+		overflowDatabases: make(map[string]bool),
+	}
+}
+
+// begin starts a scrape
+func (g *seriesGuard) begin() {
+	g.total = 0
+	g.overflowDatabases = make(map[string]bool)
+}
+
+// apply keeps the series of a collector within the collector and target limits, the overflow is
+// dropped or aggregated into series with all labels set to "other", counters are always dropped
+// as their sum over changing rows would not be monotonic. The aggregated series count against
+// the limits, room for them is reserved before rows are kept.
+func (g *seriesGuard) apply(collector string, metricGroups []*MetricGroup, metrics []prometheus.Metric) []prometheus.Metric {
+	limit := math.MaxInt
+	if collectorLimit := g.limits.collectorLimit(collector); collectorLimit > 0 {
+		limit = collectorLimit
+	}
+	if g.limits.MaxSeries > 0 {
+		limit = int(math.Min(float64(limit), math.Max(float64(g.limits.MaxSeries-g.total), 0)))
+	}
+	if len(metrics) <= limit && len(g.overflowDatabases) == 0 {
+		g.total += len(metrics)
+		delete(g.kept, collector)
+		g.keptAll[collector] = metrics
+		return metrics
+	}
+
+	groups := make(map[*prometheus.Desc]*MetricGroup)
+	for _, metricGroup := range metricGroups {
+		for _, metricDesc := range metricGroup.Metrics {
+			groups[&metricDesc.Desc] = metricGroup
+		}
+	}
+	// Histograms are not part of a group, a single group holds their labels
+	if len(metricGroups) == 1 {
+		groups[nil] = metricGroups[0]
+	}
+
+	rows := make(map[string]*guardRow)
+	keys := make([]string, len(metrics))
+	for i, m := range metrics {
+		key, database := rowKey(groups, m)
+		keys[i] = key
+		r, ok := rows[key]
+		if !ok {
+			r = &guardRow{database: database}
+			rows[key] = r
+		}
+		r.metrics = append(r.metrics, m)
+	}
+
+	// Rows kept in the previous scrape come first, then new rows in label order
+	previous := g.kept[collector]
+	if all, ok := g.keptAll[collector]; ok {
+		previous = make(map[string]bool, len(all))
+		for _, m := range all {
+			key, _ := rowKey(groups, m)
+			previous[key] = true
+		}
+		delete(g.keptAll, collector)
+	}
+	order := make([]string, 0, len(rows))
+	for key := range rows {
+		order = append(order, key)
+	}
+	sort.Slice(order, func(i, j int) bool {
+		if previous[order[i]] != previous[order[j]] {
+			return previous[order[i]]
+		}
+		return order[i] < order[j]
+	})
+
+	admit := func(limit int) (map[string]bool, map[string]bool, int) {
+		kept := make(map[string]bool)
+		overflowDatabases := make(map[string]bool)
+		used := 0
+		for _, key := range order {
+			r := rows[key]
+			if (len(r.database) == 0 || !g.overflowDatabases[r.database]) && used+len(r.metrics) <= limit {
+				kept[key] = true
+				used += len(r.metrics)
+			} else if len(r.database) != 0 {
+				overflowDatabases[r.database] = true
+			}
+		}
+		return kept, overflowDatabases, used
+	}
+	kept, overflowDatabases, used := admit(limit)
+	if used < len(metrics) && g.limits.Overflow != overflowDrop {
+		if reserve := countAggregates(metricGroups, metrics); reserve > 0 {
+			kept, overflowDatabases, used = admit(limit - reserve)
+		}
+	}
+	for database := range overflowDatabases {
+		g.overflowDatabases[database] = true
+	}
+	g.kept[collector] = kept
+	g.total += used
+	if used == len(metrics) {
+		return metrics
+	}
+
+	result := make([]prometheus.Metric, 0, used)
+	var overflow []prometheus.Metric
+	for i, m := range metrics {
+		if kept[keys[i]] {
+			result = append(result, m)
+		} else {
+			overflow = append(overflow, m)
+		}
+	}
+	g.dropped.WithLabelValues(collector).Add(float64(len(overflow)))
+	if g.limits.Overflow == overflowDrop {
+		return result
+	}
+
+	aggregated := aggregateOverflow(metricGroups, overflow)
+	// The reserve covers the aggregates unless it exceeds the limit itself
+	if room := limit - used; len(aggregated) > room {
+		g.dropped.WithLabelValues(collector).Add(float64(len(aggregated) - room))
+		aggregated = aggregated[:room]
+	}
+	g.total += len(aggregated)
+	return append(result, aggregated...)
+}
+
+// rowKey returns the key of the row a series belongs to and its database, the key is the
+// database label value if the group has one, otherwise all label values
+func rowKey(groups map[*prometheus.Desc]*MetricGroup, m prometheus.Metric) (string, string) {
+	var pb dto.Metric
+	if err := m.Write(&pb); err != nil {
+		return "", ""
+	}
+	metricGroup, ok := groups[m.Desc()]
+	if !ok {
+		metricGroup = groups[nil]
+	}
+	if metricGroup != nil {
+		if label := metricGroup.databaseOutLabel(); len(label) != 0 {
+			for _, l := range pb.Label {
+				if l.GetName() == label {
+					return "database\xff" + l.GetValue(), l.GetValue()
+				}
+			}
+		}
+	}
+	values := make([]string, 0, len(pb.Label))
+	for _, l := range pb.Label {
+		values = append(values, l.GetName()+"="+l.GetValue())
+	}
+	return strings.Join(values, "\xff"), ""
+}
+
+// aggregatedDescs maps the descriptors of the series that are aggregated on overflow to their metric
+func aggregatedDescs(metricGroups []*MetricGroup) map[*prometheus.Desc]*MetricDesc {
+	descs := make(map[*prometheus.Desc]*MetricDesc)
+	for _, metricGroup := range metricGroups {
+		for _, metricDesc := range metricGroup.Metrics {
+			if metricDesc.Aggregate != aggregateNone && metricDesc.Type != prometheus.CounterValue {
+				descs[&metricDesc.Desc] = metricDesc
+			}
+		}
+	}
+	return descs
+}
+
+// countAggregates returns the number of "other" series the metrics can be aggregated into at most
+func countAggregates(metricGroups []*MetricGroup, metrics []prometheus.Metric) int {
+	descs := aggregatedDescs(metricGroups)
+	seen := make(map[*prometheus.Desc]bool)
+	for _, m := range metrics {
+		if descs[m.Desc()] != nil {
+			seen[m.Desc()] = true
+		}
+	}
+	return len(seen)
+}
+
+// aggregateOverflow merges the series of every labelled gauge into one series labelled "other",
+// counters and histograms are left out
+func aggregateOverflow(metricGroups []*MetricGroup, metrics []prometheus.Metric) []prometheus.Metric {
+	descs := aggregatedDescs(metricGroups)
+
+	type aggregate struct {
+		metricDesc *MetricDesc
+		labels     int
+		value      float64
+	}
+	var order []*prometheus.Desc
+	aggregates := make(map[*prometheus.Desc]*aggregate)
+
+	for _, m := range metrics {
+		metricDesc := descs[m.Desc()]
+		if metricDesc == nil {
+			continue
+		}
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil || len(pb.Label) == 0 {
+			continue
+		}
+		value := metricValue(&pb)
+		if math.IsNaN(value) {
+			continue
+		}
+
+		a, ok := aggregates[m.Desc()]
+		if !ok {
+			a = &aggregate{metricDesc: metricDesc, labels: len(pb.Label), value: value}
+			aggregates[m.Desc()] = a
+			order = append(order, m.Desc())
+			continue
+		}
+		a.value = mergeValue(metricDesc.Aggregate, a.value, value)
+	}
+
+	result := make([]prometheus.Metric, 0, len(order))
+	for _, desc := range order {
+		a := aggregates[desc]
+		labelValues := make([]string, a.labels)
+		for i := range labelValues {
+			labelValues[i] = overflowLabel
+		}
+		result = append(result, prometheus.MustNewConstMetric(desc, a.metricDesc.Type, a.value, labelValues...))
+	}
+	return result
+}
+
+func metricValue(pb *dto.Metric) float64 {
+	switch {
+	case pb.Gauge != nil:
+		return pb.Gauge.GetValue()
+	case pb.Counter != nil:
+		return pb.Counter.GetValue()
+	case pb.Untyped != nil:
+		return pb.Untyped.GetValue()
+	default:
+		return math.NaN()
+	}
+}
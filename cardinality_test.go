@@ -0,0 +1,162 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// poolSeries returns the cl_active and cl_waiting series of a pool and the waiting ratio of its derived metrics
+func poolSeries(pools, derived *MetricGroup, database string) ([]prometheus.Metric, []prometheus.Metric) {
+	labels := []string{database, "app", "transaction"}
+	return []prometheus.Metric{
+		prometheus.MustNewConstMetric(&pools.Metrics["cl_active"].Desc, prometheus.GaugeValue, 1, labels...),
+		prometheus.MustNewConstMetric(&pools.Metrics["cl_waiting"].Desc, prometheus.GaugeValue, 2, labels...),
+	}, []prometheus.Metric{
+		prometheus.MustNewConstMetric(&derived.Metrics["pool_waiting_ratio"].Desc, prometheus.GaugeValue, 0.5, labels...),
+	}
+}
+
+// seriesDatabases returns the sorted database label values of the series
+func seriesDatabases(t *testing.T, metrics []prometheus.Metric) []string {
+	var databases []string
+	for _, m := range metrics {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		for _, l := range pb.Label {
+			if l.GetName() == "database" {
+				databases = append(databases, l.GetValue())
+			}
+		}
+	}
+	sort.Strings(databases)
+	return databases
+}
+
+func TestSeriesGuardKeepsRowsTogether(t *testing.T) {
+	pools := buildMetricGroup(MetricDescriptorPools, nil)
+	derived := buildMetricGroup(MetricDescriptorDerivedPools, nil)
+	guard := newSeriesGuard(LimitsConfig{Collectors: map[string]int{"pools": 3}, Overflow: overflowDrop},
+		prometheus.NewCounterVec(buildCounterOpts(InternalMetricSeriesDropped), []string{"collector"}))
+
+	scrape := func(databases ...string) ([]string, []string) {
+		guard.begin()
+		var poolMetrics, derivedMetrics []prometheus.Metric
+		for _, database := range databases {
+			p, d := poolSeries(pools, derived, database)
+			poolMetrics, derivedMetrics = append(poolMetrics, p...), append(derivedMetrics, d...)
+		}
+		kept := guard.apply("pools", []*MetricGroup{pools}, poolMetrics)
+		keptDerived := guard.apply(derivedCollector, []*MetricGroup{derived}, derivedMetrics)
+		return seriesDatabases(t, kept), seriesDatabases(t, keptDerived)
+	}
+
+	// Two series per row, a limit of 3 keeps one row whole instead of one and a half
+	kept, keptDerived := scrape("app2", "app1")
+	if want := []string{"app1", "app1"}; !slices.Equal(kept, want) {
+		t.Errorf("kept %v, want %v", kept, want)
+	}
+	if want := []string{"app1"}; !slices.Equal(keptDerived, want) {
+		t.Errorf("kept derived %v, want %v", keptDerived, want)
+	}
+
+	// A new row sorting first does not replace the kept one
+	kept, _ = scrape("app0", "app1", "app2")
+	if want := []string{"app1", "app1"}; !slices.Equal(kept, want) {
+		t.Errorf("kept %v after a new row, want %v", kept, want)
+	}
+
+	// A gone row frees its place
+	kept, _ = scrape("app0", "app2")
+	if want := []string{"app0", "app0"}; !slices.Equal(kept, want) {
+		t.Errorf("kept %v after a gone row, want %v", kept, want)
+	}
+}
+
+func TestSeriesGuardDoesNotAggregateCounters(t *testing.T) {
+	stats := buildMetricGroup(MetricDescriptorStats, nil)
+	guard := newSeriesGuard(LimitsConfig{Collectors: map[string]int{"stats": 2}, Overflow: overflowAggregate},
+		prometheus.NewCounterVec(buildCounterOpts(InternalMetricSeriesDropped), []string{"collector"}))
+	guard.begin()
+
+	var metrics []prometheus.Metric
+	for _, database := range []string{"app1", "app2", "app3"} {
+		metrics = append(metrics,
+			prometheus.MustNewConstMetric(&stats.Metrics["total_xact_count"].Desc, prometheus.CounterValue, 10, database),
+			prometheus.MustNewConstMetric(&stats.Metrics["avg_xact_count"].Desc, prometheus.GaugeValue, 1, database),
+		)
+	}
+	result := guard.apply("stats", []*MetricGroup{stats}, metrics)
+
+	var others []string
+	for _, m := range result {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		if pb.Label[0].GetValue() != overflowLabel {
+			continue
+		}
+		if pb.Counter != nil {
+			t.Errorf("counter %s aggregated into the overflow", m.Desc())
+		}
+		others = append(others, m.Desc().String())
+	}
+	if len(others) != 1 {
+		t.Errorf("got %d overflow series, want the avg_xact_count gauge only", len(others))
+	}
+}
+
+func TestSeriesGuardRemembersRowsKeptWithoutOverflow(t *testing.T) {
+	pools := buildMetricGroup(MetricDescriptorPools, nil)
+	derived := buildMetricGroup(MetricDescriptorDerivedPools, nil)
+	guard := newSeriesGuard(LimitsConfig{Collectors: map[string]int{"pools": 4}, Overflow: overflowDrop},
+		prometheus.NewCounterVec(buildCounterOpts(InternalMetricSeriesDropped), []string{"collector"}))
+
+	scrape := func(databases ...string) []string {
+		guard.begin()
+		var metrics []prometheus.Metric
+		for _, database := range databases {
+			p, _ := poolSeries(pools, derived, database)
+			metrics = append(metrics, p...)
+		}
+		return seriesDatabases(t, guard.apply("pools", []*MetricGroup{pools}, metrics))
+	}
+
+	// Both rows fit, the next scrape keeps them over a new row sorting first
+	scrape("app2", "app3")
+	if kept, want := scrape("app1", "app2", "app3"), []string{"app2", "app2", "app3", "app3"}; !slices.Equal(kept, want) {
+		t.Errorf("kept %v, want %v", kept, want)
+	}
+}
+
+func TestSeriesGuardReservesAggregates(t *testing.T) {
+	pools := buildMetricGroup(MetricDescriptorPools, nil)
+	derived := buildMetricGroup(MetricDescriptorDerivedPools, nil)
+	guard := newSeriesGuard(LimitsConfig{MaxSeries: 4, Overflow: overflowAggregate},
+		prometheus.NewCounterVec(buildCounterOpts(InternalMetricSeriesDropped), []string{"collector"}))
+	guard.begin()
+
+	var metrics []prometheus.Metric
+	for _, database := range []string{"app1", "app2", "app3"} {
+		p, _ := poolSeries(pools, derived, database)
+		metrics = append(metrics, p...)
+	}
+	kept := seriesDatabases(t, guard.apply("pools", []*MetricGroup{pools}, metrics))
+
+	// One row and the two aggregated gauges fill max_series
+	if want := []string{"app1", "app1", overflowLabel, overflowLabel}; !slices.Equal(kept, want) {
+		t.Errorf("kept %v, want %v", kept, want)
+	}
+	if guard.total != 4 {
+		t.Errorf("exported %d series, want max_series 4", guard.total)
+	}
+	if more := guard.apply("lists", []*MetricGroup{pools}, metrics[:1]); len(more) != 0 {
+		t.Errorf("exported %d series over max_series", len(more))
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var requestTimeLayouts = []string{"2006-01-02 15:04:05 MST", "2006-01-02 15:04:05"}
+
+// waitingClient is a client seen waiting on the last scrape with its labels and wait time
+type waitingClient struct {
+	labelValues []string
+	wait        float64
+}
+
+// clientWait samples the waiting clients of SHOW CLIENTS into a wait time histogram per database and user
+type clientWait struct {
+	metricGroup *MetricGroup
+	histogram   *prometheus.HistogramVec
+	waiting     map[string]waitingClient
+}
+
+func newClientWait(filters []*LabelFilter) *clientWait {
+	metricGroup := attachFilters(buildMetricGroup(MetricDescriptorClients, nil), filters)
+	return &clientWait{
+		metricGroup: metricGroup,
+		histogram:   prometheus.NewHistogramVec(buildHistogramOpts(MetricClientWait), metricGroup.Labels),
+		waiting:     map[string]waitingClient{},
+	}
+}
+
+// observe tracks the waiting clients by address, port, connect and request time. A wait is observed once
+// when its client is no longer seen waiting, with the wait time of the last scrape that saw it
+func (w *clientWait) observe(now time.Time, rows []Row) {
+	waiting := make(map[string]waitingClient, len(w.waiting))
+	for _, row := range rows {
+		if !strings.HasPrefix(cast2string(row["state"]), "waiting") {
+			continue
+		}
+		labelValues := []string{cast2string(row["database"]), cast2string(row["user"])}
+		if !matchRowFilters(w.metricGroup, labelValues) {
+			continue
+		}
+		if wait, ok := clientWaitTime(now, row); ok {
+			waiting[clientKey(row)] = waitingClient{labelValues: labelValues, wait: wait}
+		}
+	}
+	for key, client := range w.waiting {
+		if _, ok := waiting[key]; !ok {
+			w.histogram.WithLabelValues(client.labelValues...).Observe(client.wait)
+		}
+	}
+	w.waiting = waiting
+}
+
+// clientKey identifies a wait of a client, the request time tells apart the waits of one connection
+func clientKey(row Row) string {
+	return fmt.Sprintf("%s|%s|%s|%s", cast2string(row["addr"]), cast2string(row["port"]),
+		cast2string(row["connect_time"]), cast2string(row["request_time"]))
+}
+
+// clientWaitTime reads the wait and wait_us columns, older versions without them
+// fall back to the age of request_time
+func clientWaitTime(now time.Time, row Row) (float64, bool) {
+	if wait, ok := row["wait"]; ok {
+		seconds, err := parseFloat64(wait)
+		if err != nil {
+			return 0, false
+		}
+		if us, err := parseFloat64(row["wait_us"]); err == nil {
+			seconds += us * 1e-6
+		}
+		return seconds, true
+	}
+
+	var requestTime time.Time
+	switch v := row["request_time"].(type) {
+	case time.Time:
+		requestTime = v
+	case []byte, string:
+		for _, layout := range requestTimeLayouts {
+			if t, err := time.Parse(layout, cast2string(v)); err == nil {
+				requestTime = t
+				break
+			}
+		}
+		// Unknown zone abbreviations are parsed with a zero offset
+		if !knownZone(requestTime) {
+			return 0, false
+		}
+	}
+	if requestTime.IsZero() {
+		return 0, false
+	}
+	// Clock skew between the hosts must not produce negative waits
+	if wait := now.Sub(requestTime).Seconds(); wait > 0 {
+		return wait, true
+	}
+	return 0, true
+}
+
+// knownZone tells whether the zone of a parsed time has a known offset: UTC, GMT or an abbreviation of the local zone
+func knownZone(t time.Time) bool {
+	if t.Location() == time.UTC || t.Location() == time.Local {
+		return true
+	}
+	name, offset := t.Zone()
+	return name == "GMT" && offset == 0
+}
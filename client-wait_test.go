@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// waitingRow returns a SHOW CLIENTS row of a client waiting since requestTime
+func waitingRow(port int64, requestTime string, wait int64) Row {
+	return Row{
+		"database": []byte("app"), "user": []byte("app"), "state": []byte("waiting"),
+		"addr": []byte("10.0.0.1"), "port": port, "connect_time": []byte("2024-01-02 15:00:00 UTC"),
+		"request_time": []byte(requestTime), "wait": wait, "wait_us": int64(0),
+	}
+}
+
+// histogramSamples returns the sample count and sum of the histogram of the app database
+func histogramSamples(t *testing.T, w *clientWait) (uint64, float64) {
+	var pb dto.Metric
+	if err := w.histogram.WithLabelValues("app", "app").(prometheus.Metric).Write(&pb); err != nil {
+		t.Fatal(err)
+	}
+	return pb.GetHistogram().GetSampleCount(), pb.GetHistogram().GetSampleSum()
+}
+
+func TestClientWaitObservesEachWaitOnce(t *testing.T) {
+	w := newClientWait(nil)
+	now := time.Unix(1700000000, 0)
+
+	// The client waits across two scrapes, a second client across one
+	w.observe(now, []Row{waitingRow(5000, "2024-01-02 15:01:00 UTC", 2), waitingRow(5001, "2024-01-02 15:01:00 UTC", 1)})
+	w.observe(now, []Row{waitingRow(5000, "2024-01-02 15:01:00 UTC", 7)})
+	if count, _ := histogramSamples(t, w); count != 1 {
+		t.Fatalf("observed %d waits while the first is still waiting, want 1", count)
+	}
+
+	// Its next request is a new wait, the ended wait is observed with its last wait time
+	w.observe(now, []Row{waitingRow(5000, "2024-01-02 15:02:00 UTC", 3)})
+	if count, sum := histogramSamples(t, w); count != 2 || sum != 8 {
+		t.Errorf("got %d waits summing to %v, want 2 summing to 8", count, sum)
+	}
+	w.observe(now, nil)
+	if count, sum := histogramSamples(t, w); count != 3 || sum != 11 {
+		t.Errorf("got %d waits summing to %v, want 3 summing to 11", count, sum)
+	}
+}
+
+func TestClientWaitTimeFromRequestTime(t *testing.T) {
+	now := time.Date(2024, 1, 2, 15, 0, 30, 0, time.UTC)
+	tests := []struct {
+		requestTime string
+		want        float64
+		ok          bool
+	}{
+		{requestTime: "2024-01-02 15:00:00 UTC", want: 30, ok: true},
+		{requestTime: "2024-01-02 15:00:10 GMT", want: 20, ok: true},
+		{requestTime: "2024-01-02 15:00:20", want: 10, ok: true},
+		{requestTime: "2024-01-02 15:01:00 UTC", want: 0, ok: true},
+		{requestTime: "2024-01-02 15:00:00 XYZ", ok: false},
+		{requestTime: "garbage", ok: false},
+	}
+
+	for _, tt := range tests {
+		got, ok := clientWaitTime(now, Row{"request_time": []byte(tt.requestTime)})
+		if ok != tt.ok || (ok && got != tt.want) {
+			t.Errorf("clientWaitTime(%q) = %v, %v, want %v, %v", tt.requestTime, got, ok, tt.want, tt.ok)
+		}
+	}
+}
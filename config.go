@@ -0,0 +1,419 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the exporter configuration loaded from the YAML config file
+type Config struct {
+	Web          WebConfig                 `yaml:"web"`
+	Targets      []TargetConfig            `yaml:"targets"`
+	Collectors   []string                  `yaml:"collectors"`
+	LabelFilters []*LabelFilter            `yaml:"label_filters"`
+	Limits       LimitsConfig              `yaml:"limits"`
+	ConstLabels  map[string]string         `yaml:"const_labels"`
+	Relabel      map[string]*RelabelConfig `yaml:"relabel"`
+	Values       ValueConfig               `yaml:"values"`
+
+	// DatabaseInfoMetric keys the DATABASES gauges by name and exports the other labels in pgbouncer_database_info
+	DatabaseInfoMetric bool `yaml:"database_info_metric"`
+	// ClientWaitHistogram samples the wait time of waiting clients from SHOW CLIENTS on every scrape
+	ClientWaitHistogram bool          `yaml:"client_wait_histogram"`
+	Sampler             SamplerConfig `yaml:"sampler"`
+	// StatsRates exports per-second rates of the STATS counters between consecutive scrapes
+	StatsRates bool `yaml:"stats_rates"`
+}
+
+// SamplerConfig polls SHOW POOLS between scrapes, a zero interval disables it
+type SamplerConfig struct {
+	Interval time.Duration `yaml:"interval"`
+	MaxPools int           `yaml:"max_pools"`
+}
+
+func (s SamplerConfig) validate() error {
+	if s.Interval == 0 {
+		return nil
+	}
+	if s.Interval < minSamplerInterval {
+		return fmt.Errorf("sampler: interval %v is below %v", s.Interval, minSamplerInterval)
+	}
+	if s.MaxPools <= 0 {
+		return fmt.Errorf("sampler: max_pools must be positive")
+	}
+	return nil
+}
+
+const (
+	parseErrorSkip    = "skip"
+	parseErrorDefault = "default"
+)
+
+// ValueConfig sets how values that cannot be converted to a number are exported
+type ValueConfig struct {
+	OnParseError string  `yaml:"on_parse_error"`
+	Default      float64 `yaml:"default"`
+}
+
+// LimitsConfig caps the series of a target per scrape, 0 means unlimited
+type LimitsConfig struct {
+	MaxSeries             int            `yaml:"max_series"`
+	MaxSeriesPerCollector int            `yaml:"max_series_per_collector"`
+	Collectors            map[string]int `yaml:"collectors"`
+	Overflow              string         `yaml:"overflow"`
+}
+
+func (l LimitsConfig) collectorLimit(collector string) int {
+	if limit, ok := l.Collectors[collector]; ok {
+		return limit
+	}
+	return l.MaxSeriesPerCollector
+}
+
+// guardedCollectors are the series limit names of the metrics not scraped by a collector of their own
+var guardedCollectors = []string{
+	derivedCollector, backendCollectorName, MetricDescriptorStatsRates.Prefix,
+	MetricDescriptorPoolSamples.Prefix, MetricDescriptorClients.Prefix,
+}
+
+func (l LimitsConfig) validate() error {
+	if l.Overflow != overflowAggregate && l.Overflow != overflowDrop {
+		return fmt.Errorf("limits: unknown overflow %q, expected aggregate or drop", l.Overflow)
+	}
+	for name := range l.Collectors {
+		if contains(guardedCollectors, name) {
+			continue
+		}
+		if err := validateCollectors([]string{name}); err != nil {
+			return fmt.Errorf("limits: %v", err)
+		}
+	}
+	return nil
+}
+
+type WebConfig struct {
+	ListenAddress string `yaml:"listen_address"`
+	MetricsPath   string `yaml:"metrics_path"`
+	// MaxRequests limits the concurrent scrapes, 0 for unlimited
+	MaxRequests int `yaml:"max_requests"`
+}
+
+type TargetConfig struct {
+	Name         string        `yaml:"name"`
+	DSN          string        `yaml:"dsn"`
+	DSNFile      string        `yaml:"dsn_file"`
+	SocketDir    string        `yaml:"socket_dir"`
+	Port         int           `yaml:"port"`
+	User         string        `yaml:"user"`
+	PasswordFile string        `yaml:"password_file"`
+	PassFile     string        `yaml:"passfile"`
+	TLS          TLSConfig     `yaml:"tls"`
+	Collectors   []string      `yaml:"collectors"`
+	Limits       *LimitsConfig `yaml:"limits"`
+	Process      ProcessConfig `yaml:"process"`
+	Log          LogConfig     `yaml:"log"`
+	Syslog       SyslogConfig  `yaml:"syslog"`
+	Backend      BackendConfig `yaml:"backend"`
+	// Peers scrapes the processes of a so_reuseport group through their own admin sockets
+	Peers      []PeerConfig `yaml:"peers"`
+	PeerTotals bool         `yaml:"peer_totals"`
+
+	// peer_id label of a target expanded from Peers
+	peerID string
+}
+
+// TLSConfig holds the client TLS options for the admin console connection
+type TLSConfig struct {
+	SSLMode    string `yaml:"sslmode"`
+	RootCert   string `yaml:"sslrootcert"`
+	Cert       string `yaml:"sslcert"`
+	Key        string `yaml:"sslkey"`
+	ServerName string `yaml:"server_name"`
+}
+
+// displayName identifies the target in logs without exposing credentials
+func (t *TargetConfig) displayName() string {
+	switch {
+	case len(t.Name) != 0 && len(t.peerID) != 0:
+		return t.Name + "/" + t.peerID
+	case len(t.Name) != 0:
+		return t.Name
+	case len(t.SocketDir) != 0:
+		return t.SocketDir
+	case len(t.DSNFile) != 0:
+		return t.DSNFile
+	default:
+		return redactDSN(t.DSN)
+	}
+}
+
+// LabelFilter keeps only the rows whose Label value matches Include and does not match Exclude
+type LabelFilter struct {
+	Label   string `yaml:"label"`
+	Include string `yaml:"include"`
+	Exclude string `yaml:"exclude"`
+
+	include *regexp.Regexp
+	exclude *regexp.Regexp
+}
+
+func (f *LabelFilter) compile() error {
+	var err error
+	if len(f.Include) != 0 {
+		if f.include, err = regexp.Compile("^(?:" + f.Include + ")$"); err != nil {
+			return err
+		}
+	}
+	if len(f.Exclude) != 0 {
+		if f.exclude, err = regexp.Compile("^(?:" + f.Exclude + ")$"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *LabelFilter) match(value string) bool {
+	if f.include != nil && !f.include.MatchString(value) {
+		return false
+	}
+	if f.exclude != nil && f.exclude.MatchString(value) {
+		return false
+	}
+	return true
+}
+
+func matchFilters(filters []*LabelFilter, label string, value string) bool {
+	for _, f := range filters {
+		if f.Label == label && !f.match(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// defaultConfig builds the config from command line flags and environment
+func defaultConfig() *Config {
+	return &Config{
+		Web: WebConfig{
+			ListenAddress: net.JoinHostPort(metricsHost, metricsPort),
+			MetricsPath:   metricsPath,
+			MaxRequests:   webMaxRequests,
+		},
+		// SHOW PEERS has its own peer_id column, the label is taken by target groups
+		Relabel: map[string]*RelabelConfig{
+			MetricDescriptorPeers.Prefix: {Rename: map[string]string{"peer_id": "remote_peer_id"}},
+		},
+		DatabaseInfoMetric:  databaseInfoMetric,
+		ClientWaitHistogram: clientWaitHistogram,
+		StatsRates:          statsRatesEnabled,
+		Sampler: SamplerConfig{
+			Interval: samplerInterval,
+			MaxPools: samplerMaxPools,
+		},
+		Values: ValueConfig{
+			OnParseError: onParseError,
+			Default:      parseErrorValue,
+		},
+		Limits: LimitsConfig{
+			MaxSeries:             maxSeries,
+			MaxSeriesPerCollector: maxSeriesPerCollector,
+			Overflow:              seriesOverflow,
+		},
+	}
+}
+
+// defaultTarget builds the target from command line flags and environment
+func defaultTarget() TargetConfig {
+	t := TargetConfig{
+		DSN:          dataSourceName,
+		DSNFile:      dataSourceNameFile,
+		SocketDir:    socketDir,
+		Port:         socketPort,
+		User:         dataSourceUser,
+		PasswordFile: dataSourcePasswordFile,
+		TLS: TLSConfig{
+			SSLMode:    sslMode,
+			RootCert:   sslRootCert,
+			Cert:       sslCert,
+			Key:        sslKey,
+			ServerName: sslServerName,
+		},
+		Process: ProcessConfig{
+			PidFile: processPidFile,
+			Name:    processName,
+		},
+		Log: LogConfig{
+			File:         logFile,
+			PollInterval: logPollInterval,
+			MaxSeries:    logMaxSeries,
+		},
+		Syslog: SyslogConfig{
+			Listen:  syslogListen,
+			Forward: syslogForward,
+		},
+		Backend: BackendConfig{
+			User:         backendUser,
+			PasswordFile: backendPasswordFile,
+			SSLMode:      backendSSLMode,
+			ClientAddrs:  backendClientAddrs,
+		},
+	}
+	if len(t.DSNFile) != 0 || len(t.SocketDir) != 0 {
+		t.DSN = ""
+	}
+	return t
+}
+
+// LoadConfig reads the config file, falling back to flag values for missing settings
+func LoadConfig(path string) (*Config, error) {
+	cfg := defaultConfig()
+	if len(path) != 0 {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err = yaml.UnmarshalStrict(content, cfg); err != nil {
+			return nil, fmt.Errorf("parse %s: %v", path, err)
+		}
+	}
+	if len(cfg.Targets) == 0 {
+		cfg.Targets = []TargetConfig{defaultTarget()}
+	}
+	if len(includeDatabases) != 0 || len(excludeDatabases) != 0 {
+		cfg.LabelFilters = append(cfg.LabelFilters, &LabelFilter{Label: "database", Include: includeDatabases, Exclude: excludeDatabases})
+	}
+	for k, v := range constLabels {
+		if _, ok := cfg.ConstLabels[k]; !ok {
+			if cfg.ConstLabels == nil {
+				cfg.ConstLabels = make(map[string]string)
+			}
+			cfg.ConstLabels[k] = v
+		}
+	}
+	if len(excludeUsers) != 0 {
+		cfg.LabelFilters = append(cfg.LabelFilters, &LabelFilter{Label: "user", Exclude: excludeUsers})
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (cfg *Config) validate() error {
+	if err := validateCollectors(cfg.Collectors); err != nil {
+		return err
+	}
+	if err := cfg.Limits.validate(); err != nil {
+		return err
+	}
+	if err := cfg.Sampler.validate(); err != nil {
+		return err
+	}
+	if cfg.Web.MaxRequests < 0 {
+		return fmt.Errorf("web: max_requests must not be negative")
+	}
+	if cfg.Values.OnParseError != parseErrorSkip && cfg.Values.OnParseError != parseErrorDefault {
+		return fmt.Errorf("values: unknown on_parse_error %q, expected skip or default", cfg.Values.OnParseError)
+	}
+	for name := range cfg.ConstLabels {
+		if !labelNameRe.MatchString(name) || name == "target" || name == peerLabel {
+			return fmt.Errorf("const label: invalid label name %q", name)
+		}
+	}
+	for name, relabel := range cfg.Relabel {
+		def := findScrapeDefinition(name)
+		if def == nil || len(def.Descriptor.Labels) == 0 {
+			return fmt.Errorf("relabel: collector %q has no labels", name)
+		}
+		if err := relabel.validate(def.Descriptor); err != nil {
+			return fmt.Errorf("relabel %s: %v", name, err)
+		}
+	}
+	names := make(map[string]bool)
+	listenAddresses := make(map[string]bool)
+	for i, t := range cfg.Targets {
+		sources := 0
+		for _, source := range []string{t.DSN, t.DSNFile, t.SocketDir} {
+			if len(source) != 0 {
+				sources++
+			}
+		}
+		if len(t.Peers) != 0 {
+			if err := t.validatePeers(); err != nil {
+				return err
+			}
+		} else if sources != 1 {
+			return fmt.Errorf("target #%d: exactly one of dsn, dsn_file or socket_dir is required", i)
+		}
+		if len(cfg.Targets) > 1 && len(t.Name) == 0 {
+			return fmt.Errorf("target #%d: name is required when several targets are configured", i)
+		}
+		if names[t.Name] {
+			return fmt.Errorf("duplicate target name %q", t.Name)
+		}
+		names[t.Name] = true
+		if len(t.TLS.Cert) != 0 && len(t.TLS.Key) == 0 || len(t.TLS.Cert) == 0 && len(t.TLS.Key) != 0 {
+			return fmt.Errorf("target %q: sslcert and sslkey must be set together", t.Name)
+		}
+		if err := validateCollectors(t.Collectors); err != nil {
+			return fmt.Errorf("target %q: %v", t.Name, err)
+		}
+		if t.Backend.enabled() {
+			if err := t.Backend.validate(); err != nil {
+				return fmt.Errorf("target %q: %v", t.Name, err)
+			}
+			collectors := cfg.Collectors
+			if len(t.Collectors) != 0 {
+				collectors = t.Collectors
+			}
+			if len(collectors) != 0 && (!contains(collectors, MetricDescriptorPools.Prefix) || !contains(collectors, MetricDescriptorDatabases.Prefix)) {
+				return fmt.Errorf("target %q: backend needs the pools and databases collectors", t.Name)
+			}
+		}
+		for _, peer := range t.expand() {
+			if err := peer.Syslog.validate(listenAddresses); err != nil {
+				return fmt.Errorf("target %q: %v", peer.displayName(), err)
+			}
+		}
+		if t.Limits != nil {
+			if len(t.Limits.Overflow) == 0 {
+				t.Limits.Overflow = cfg.Limits.Overflow
+			}
+			if err := t.Limits.validate(); err != nil {
+				return fmt.Errorf("target %q: %v", t.Name, err)
+			}
+		}
+	}
+	for _, f := range cfg.LabelFilters {
+		if len(f.Label) == 0 {
+			return fmt.Errorf("label filter: label is required")
+		}
+		if err := f.compile(); err != nil {
+			return fmt.Errorf("label filter %q: %v", f.Label, err)
+		}
+	}
+	return nil
+}
+
+func validateCollectors(collectors []string) error {
+	for _, name := range collectors {
+		if findScrapeDefinition(name) == nil {
+			return fmt.Errorf("unknown collector %q", name)
+		}
+	}
+	return nil
+}
+
+func findScrapeDefinition(name string) *ScrapeDefinition {
+	for i := range ScrapeDefinitions {
+		if ScrapeDefinitions[i].Descriptor.Prefix == name {
+			return &ScrapeDefinitions[i]
+		}
+	}
+	return nil
+}
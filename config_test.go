@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfigFilterFlags(t *testing.T) {
+	defer func(include, exclude, users string) {
+		includeDatabases, excludeDatabases, excludeUsers = include, exclude, users
+	}(includeDatabases, excludeDatabases, excludeUsers)
+
+	// The flags that default the config are not parsed in tests
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(`
+limits: {overflow: aggregate}
+values: {on_parse_error: skip}
+targets:
+  - socket_dir: /var/run/pgbouncer
+label_filters:
+  - label: pool_mode
+    exclude: session
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	type match struct {
+		label, value string
+		match        bool
+	}
+	tests := []struct {
+		include, exclude, users string
+		want                    []match
+	}{
+		{
+			want: []match{{"database", "app", true}, {"pool_mode", "session", false}, {"pool_mode", "transaction", true}},
+		},
+		{
+			include: "app.*", exclude: "app_test",
+			want: []match{{"database", "app", true}, {"database", "app_prod", true}, {"database", "app_test", false}, {"database", "pgbouncer", false}, {"user", "alice", true}},
+		},
+		{
+			exclude: "pgbouncer", users: "stats|monitor",
+			want: []match{{"database", "app", true}, {"database", "pgbouncer", false}, {"database", "pgbouncer_x", true}, {"user", "monitor", false}, {"user", "alice", true}},
+		},
+	}
+
+	for _, tt := range tests {
+		includeDatabases, excludeDatabases, excludeUsers = tt.include, tt.exclude, tt.users
+		cfg, err := LoadConfig(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, w := range tt.want {
+			if got := matchFilters(cfg.LabelFilters, w.label, w.value); got != w.match {
+				t.Errorf("include %q exclude %q users %q: %s=%q matched %v, want %v",
+					tt.include, tt.exclude, tt.users, w.label, w.value, got, w.match)
+			}
+		}
+	}
+
+	includeDatabases = "("
+	if _, err := LoadConfig(path); err == nil {
+		t.Errorf("invalid include regex accepted")
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"math"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const derivedCollector = "derived"
+
+// clientConnectionColumns are the POOLS columns of client connections counted against max_client_conn,
+// the cancel request columns of newer versions are left out like sv_active_cancel and sv_being_canceled
+var clientConnectionColumns = []string{"cl_active", "cl_waiting"}
+
+// deriveMetrics joins the POOLS, DATABASES and CONFIG rows of the same scrape into saturation metrics
+func (c *Collector) deriveMetrics(results map[string][]Row) []prometheus.Metric {
+	var metrics []prometheus.Metric
+	emit := func(metricGroup *MetricGroup, name string, value float64, labelValues ...string) {
+		metricDesc := metricGroup.Metrics[name]
+		if metricDesc == nil || math.IsNaN(value) || math.IsInf(value, 0) {
+			return
+		}
+		metrics = append(metrics, prometheus.MustNewConstMetric(&metricDesc.Desc, metricDesc.Type, value, labelValues...))
+	}
+
+	pools, havePools := results[MetricDescriptorPools.Prefix]
+	databases, haveDatabases := results[MetricDescriptorDatabases.Prefix]
+	config, haveConfig := results[MetricDescriptorConfig.Prefix]
+
+	// POOLS reference a database by "database", DATABASES name it "name"
+	databaseRows := make(map[string]Row)
+	for _, row := range databases {
+		databaseRows[cast2string(row["name"])] = row
+	}
+
+	clients := 0.0
+	for _, row := range pools {
+		poolClients := sumColumns(row, clientConnectionColumns)
+		clients += poolClients
+
+		labelValues := []string{cast2string(row["database"]), cast2string(row["user"]), cast2string(row["pool_mode"])}
+		if !matchRowFilters(c.derivedPools, labelValues) {
+			continue
+		}
+
+		if poolClients > 0 {
+			emit(c.derivedPools, "pool_waiting_ratio", cast2Float64(row["cl_waiting"], 1)/poolClients, labelValues...)
+		} else {
+			emit(c.derivedPools, "pool_waiting_ratio", 0, labelValues...)
+		}
+
+		databaseRow, ok := databaseRows[labelValues[0]]
+		if !ok {
+			continue
+		}
+		poolSize := cast2Float64(databaseRow["pool_size"], 1)
+		if !(poolSize > 0) {
+			continue
+		}
+		emit(c.derivedPools, "pool_server_utilization", cast2Float64(row["sv_active"], 1)/poolSize, labelValues...)
+		if reservePool, ok := databaseRow["reserve_pool"]; ok {
+			inUse := math.Max(sumColumns(row, serverConnectionColumns)-poolSize, 0)
+			emit(c.derivedPools, "pool_reserve_in_use", math.Min(inUse, cast2Float64(reservePool, 1)), labelValues...)
+		}
+	}
+
+	if haveDatabases {
+		for name, row := range databaseRows {
+			// max_connections is the effective max_db_connections, 0 means unlimited
+			maxConnections := cast2Float64(row["max_connections"], 1)
+			if !(maxConnections > 0) || !matchRowFilters(c.derivedDatabases, []string{name}) {
+				continue
+			}
+			headroom := maxConnections - cast2Float64(row["current_connections"], 1)
+			emit(c.derivedDatabases, "database_server_headroom", headroom, name)
+		}
+	}
+
+	if havePools && haveConfig {
+		for _, row := range config {
+			if cast2string(row["key"]) != "max_client_conn" {
+				continue
+			}
+			maxClientConn := cast2Float64(row["value"], 1)
+			if !math.IsNaN(maxClientConn) {
+				emit(c.derivedClients, "client_headroom", maxClientConn-clients)
+			}
+		}
+	}
+	return metrics
+}
+
+// matchRowFilters applies the label filters of the group to label values in the order of its labels
+func matchRowFilters(metricGroup *MetricGroup, labelValues []string) bool {
+	for i, label := range metricGroup.Labels {
+		if !matchFilters(metricGroup.Filters, label, labelValues[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// sumColumns adds up the values of the columns, missing columns count 0
+func sumColumns(row Row, columns []string) float64 {
+	sum := 0.0
+	for _, column := range columns {
+		if v := cast2Float64(row[column], 1); !math.IsNaN(v) {
+			sum += v
+		}
+	}
+	return sum
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// newDerivedCollector returns a collector with only the derived metric groups
+func newDerivedCollector() *Collector {
+	return &Collector{
+		derivedPools:     buildMetricGroup(MetricDescriptorDerivedPools, nil),
+		derivedDatabases: buildMetricGroup(MetricDescriptorDerivedDatabases, nil),
+		derivedClients:   buildMetricGroup(MetricDescriptorDerivedClients, nil),
+		derivedFds:       buildMetricGroup(MetricDescriptorDerivedFds, nil),
+	}
+}
+
+// derivedValues returns the derived metrics by name and label values
+func derivedValues(t *testing.T, c *Collector, metrics []prometheus.Metric) map[string]float64 {
+	t.Helper()
+	names := make(map[*prometheus.Desc]string)
+	for _, metricGroup := range []*MetricGroup{c.derivedPools, c.derivedDatabases, c.derivedClients, c.derivedFds} {
+		for name, metricDesc := range metricGroup.Metrics {
+			names[&metricDesc.Desc] = name
+		}
+	}
+	values := make(map[string]float64)
+	for _, m := range metrics {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		// The label values in the order of the descriptor: database, user, pool_mode
+		labels := make(map[string]string)
+		for _, l := range pb.GetLabel() {
+			labels[l.GetName()] = l.GetValue()
+		}
+		key := []string{names[m.Desc()]}
+		for _, label := range []string{"database", "user", "pool_mode"} {
+			if v, ok := labels[label]; ok {
+				key = append(key, v)
+			}
+		}
+		values[strings.Join(key, " ")] = pb.GetGauge().GetValue()
+	}
+	return values
+}
+
+func TestDeriveMetrics(t *testing.T) {
+	config := []Row{{"key": []byte("max_client_conn"), "value": []byte("100")}, {"key": []byte("pool_mode"), "value": []byte("session")}}
+	pools := func(version string) []Row {
+		return readFixtureRows(t, filepath.Join("testdata", "show-pools", "pgbouncer-"+version+".txt"))
+	}
+	databases := func(version string) []Row {
+		return readFixtureRows(t, filepath.Join("testdata", "show-databases", "pgbouncer-"+version+".txt"))
+	}
+	// The cancel request columns of 1.21 do not count as client or server connections
+	all := map[string]float64{
+		"pool_waiting_ratio app alice transaction":              0.25,
+		"pool_server_utilization app alice transaction":         1,
+		"pool_reserve_in_use app alice transaction":             1,
+		"pool_waiting_ratio app bob transaction":                0,
+		"pool_server_utilization app bob transaction":           0,
+		"pool_reserve_in_use app bob transaction":               0,
+		"pool_waiting_ratio pgbouncer pgbouncer statement":      0,
+		"pool_server_utilization pgbouncer pgbouncer statement": 0,
+		"pool_reserve_in_use pgbouncer pgbouncer statement":     0,
+		"database_server_headroom app":                          45,
+		"client_headroom":                                       91,
+	}
+
+	tests := []struct {
+		name    string
+		results map[string][]Row
+		want    map[string]float64
+	}{
+		{
+			name:    "1.12",
+			results: map[string][]Row{MetricDescriptorPools.Prefix: pools("1.12"), MetricDescriptorDatabases.Prefix: databases("1.12"), MetricDescriptorConfig.Prefix: config},
+			want:    all,
+		},
+		{
+			name:    "1.21",
+			results: map[string][]Row{MetricDescriptorPools.Prefix: pools("1.21"), MetricDescriptorDatabases.Prefix: databases("1.21"), MetricDescriptorConfig.Prefix: config},
+			want:    all,
+		},
+		{
+			name:    "without databases",
+			results: map[string][]Row{MetricDescriptorPools.Prefix: pools("1.21"), MetricDescriptorConfig.Prefix: config},
+			want: map[string]float64{
+				"pool_waiting_ratio app alice transaction":         0.25,
+				"pool_waiting_ratio app bob transaction":           0,
+				"pool_waiting_ratio pgbouncer pgbouncer statement": 0,
+				"client_headroom": 91,
+			},
+		},
+		{
+			name:    "without pools",
+			results: map[string][]Row{MetricDescriptorDatabases.Prefix: databases("1.21"), MetricDescriptorConfig.Prefix: config},
+			want:    map[string]float64{"database_server_headroom app": 45},
+		},
+		{
+			name:    "without config",
+			results: map[string][]Row{MetricDescriptorPools.Prefix: pools("1.12"), MetricDescriptorDatabases.Prefix: databases("1.12")},
+			want: func() map[string]float64 {
+				want := make(map[string]float64)
+				for k, v := range all {
+					if k != "client_headroom" {
+						want[k] = v
+					}
+				}
+				return want
+			}(),
+		},
+		{
+			name:    "nothing",
+			results: map[string][]Row{},
+			want:    map[string]float64{},
+		},
+	}
+
+	for _, tt := range tests {
+		c := newDerivedCollector()
+		got := derivedValues(t, c, c.deriveMetrics(tt.results))
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+		for series, v := range tt.want {
+			if value, ok := got[series]; !ok || value != v {
+				t.Errorf("%s: %s = %v, want %v", tt.name, series, value, v)
+			}
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/lib/pq"
+)
+
+const (
+	reasonSocketMissing     = "socket_missing"
+	reasonPermissionDenied  = "permission_denied"
+	reasonConnectionRefused = "connection_refused"
+	reasonTimeout           = "timeout"
+	reasonAuthFailed        = "auth_failed"
+	reasonTLS               = "tls"
+	reasonNetwork           = "network"
+)
+
+// connectionErrorReason classifies errors that prevent connecting to pgbouncer, returns "" for query errors
+func connectionErrorReason(err error) string {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		if isAuthError(pqErr) {
+			return reasonAuthFailed
+		}
+		return ""
+	}
+
+	var certErr x509.CertificateInvalidError
+	var hostErr x509.HostnameError
+	var authorityErr x509.UnknownAuthorityError
+	var recordErr tls.RecordHeaderError
+	switch {
+	case errors.Is(err, pq.ErrSSLNotSupported), errors.As(err, &certErr), errors.As(err, &hostErr),
+		errors.As(err, &authorityErr), errors.As(err, &recordErr):
+		return reasonTLS
+	case errors.Is(err, syscall.ENOENT):
+		return reasonSocketMissing
+	case errors.Is(err, syscall.EACCES), errors.Is(err, syscall.EPERM):
+		return reasonPermissionDenied
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return reasonConnectionRefused
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return reasonTimeout
+		}
+		return reasonNetwork
+	}
+	return ""
+}
+
+// isAuthError reports login failures, PgBouncer reports most of them as protocol violations
+func isAuthError(err *pq.Error) bool {
+	if err.Code.Class() == "28" {
+		return true
+	}
+	msg := strings.ToLower(err.Message)
+	return err.Code == "08P01" && (strings.Contains(msg, "authentication failed") ||
+		strings.Contains(msg, "no such user") || strings.Contains(msg, "not allowed"))
+}
+
+// connectionErrorHint describes the likely cause of a connection error
+func connectionErrorHint(reason string) string {
+	switch reason {
+	case reasonSocketMissing:
+		return "socket not found, check that pgbouncer is running and the socket directory and port match its unix_socket_dir and listen_port"
+	case reasonPermissionDenied:
+		return "no access to the socket, check unix_socket_mode and the exporter user"
+	case reasonConnectionRefused:
+		return "nothing listens on the address, check that pgbouncer is running and listen_addr/listen_port"
+	case reasonTimeout:
+		return "connection timed out"
+	case reasonAuthFailed:
+		return "pgbouncer rejected the login, check the user is in admin_users or stats_users and the auth settings"
+	case reasonTLS:
+		return "TLS handshake failed, check sslmode, certificates and server name"
+	default:
+		return "network error"
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/lib/pq"
+)
+
+const (
+	redacted          = "xxxxx"
+	defaultSocketPort = 6432
+)
+
+var (
+	passwordOptionRe = regexp.MustCompile(`(password\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+	urlPasswordRe    = regexp.MustCompile(`(postgres(?:ql)?://[^:/@\s]*:)[^/]*@`)
+)
+
+// targetConnector builds the connection string on every connect,
+// so rotated DSN and password files are picked up on reconnect
+type targetConnector struct {
+	target TargetConfig
+}
+
+func (c *targetConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	opts, err := c.target.buildOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	// Verify and send SNI for the server name, but dial the configured host
+	var dialer pq.Dialer
+	if sni := c.target.TLS.ServerName; len(sni) != 0 && !strings.HasPrefix(opts["host"], "/") {
+		host := opts["host"]
+		if len(host) == 0 {
+			host = "localhost"
+		}
+		dialer = &addressDialer{host: host}
+		opts["host"] = sni
+	}
+
+	dsn := formatDSN(opts)
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		return nil, redactError(err, dsn)
+	}
+	if dialer != nil {
+		connector.Dialer(dialer)
+	}
+	conn, err := connector.Connect(ctx)
+	if err != nil {
+		return nil, redactError(err, dsn)
+	}
+	return conn, nil
+}
+
+func (c *targetConnector) Driver() driver.Driver {
+	return &pq.Driver{}
+}
+
+// buildDSN resolves the target into a key/value connection string
+func (t *TargetConfig) buildDSN() (string, error) {
+	opts, err := t.buildOptions()
+	if err != nil {
+		return "", err
+	}
+	return formatDSN(opts), nil
+}
+
+// buildOptions resolves the DSN file, user, password and TLS sources of the target into connection options
+func (t *TargetConfig) buildOptions() (map[string]string, error) {
+	dsn := t.DSN
+	if len(t.SocketDir) != 0 {
+		port := t.Port
+		if port == 0 {
+			port = defaultSocketPort
+		}
+		// PgBouncer does not offer TLS on unix sockets, peer auth needs no password
+		dsn = formatDSN(map[string]string{
+			"host":    t.SocketDir,
+			"port":    strconv.Itoa(port),
+			"dbname":  "pgbouncer",
+			"sslmode": "disable",
+		})
+	}
+	if len(t.DSNFile) != 0 {
+		content, err := os.ReadFile(t.DSNFile)
+		if err != nil {
+			return nil, err
+		}
+		dsn = strings.TrimSpace(string(content))
+	}
+
+	opts, err := parseDSN(dsn)
+	if err != nil {
+		return nil, redactError(err, dsn)
+	}
+	if len(t.User) != 0 {
+		opts["user"] = t.User
+	}
+
+	switch {
+	case len(t.PasswordFile) != 0:
+		content, err := os.ReadFile(t.PasswordFile)
+		if err != nil {
+			return nil, err
+		}
+		opts["password"] = strings.TrimRight(string(content), "\r\n")
+	case len(opts["password"]) == 0:
+		password, err := lookupPassFile(t.passFile(), opts)
+		if err != nil {
+			return nil, err
+		}
+		if len(password) != 0 {
+			opts["password"] = password
+		}
+	}
+
+	tlsOpts := map[string]string{
+		"sslmode":     t.TLS.SSLMode,
+		"sslrootcert": t.TLS.RootCert,
+		"sslcert":     t.TLS.Cert,
+		"sslkey":      t.TLS.Key,
+	}
+	for k, v := range tlsOpts {
+		if len(v) != 0 {
+			opts[k] = v
+		}
+	}
+
+	return opts, nil
+}
+
+// passFile returns the pgpass file of the target, defaulting to PGPASSFILE and ~/.pgpass like libpq
+func (t *TargetConfig) passFile() string {
+	return defaultPassFile(t.PassFile)
+}
+
+// defaultPassFile returns the pgpass file if set, otherwise PGPASSFILE or ~/.pgpass like libpq
+func defaultPassFile(path string) string {
+	if len(path) != 0 {
+		return path
+	}
+	if file := os.Getenv("PGPASSFILE"); len(file) != 0 {
+		return file
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".pgpass")
+	}
+	return ""
+}
+
+// parseDSN splits a URL or key/value connection string into options
+func parseDSN(dsn string) (map[string]string, error) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		kv, err := pq.ParseURL(dsn)
+		if err != nil {
+			return nil, err
+		}
+		dsn = kv
+	}
+
+	opts := make(map[string]string)
+	s := []rune(dsn)
+	for i := 0; ; {
+		for i < len(s) && unicode.IsSpace(s[i]) {
+			i++
+		}
+		if i >= len(s) {
+			return opts, nil
+		}
+
+		start := i
+		for i < len(s) && s[i] != '=' && !unicode.IsSpace(s[i]) {
+			i++
+		}
+		key := string(s[start:i])
+		for i < len(s) && unicode.IsSpace(s[i]) {
+			i++
+		}
+		if i >= len(s) || s[i] != '=' {
+			return nil, fmt.Errorf("missing \"=\" after %q in connection string", key)
+		}
+		i++
+		for i < len(s) && unicode.IsSpace(s[i]) {
+			i++
+		}
+
+		var value []rune
+		quoted := i < len(s) && s[i] == '\''
+		if quoted {
+			i++
+		}
+		for ; i < len(s); i++ {
+			if quoted && s[i] == '\'' {
+				break
+			}
+			if !quoted && unicode.IsSpace(s[i]) {
+				break
+			}
+			if s[i] == '\\' && i+1 < len(s) {
+				i++
+			}
+			value = append(value, s[i])
+		}
+		if quoted {
+			if i >= len(s) {
+				return nil, fmt.Errorf("unterminated quoted string in connection string")
+			}
+			i++
+		}
+		opts[key] = string(value)
+	}
+}
+
+// formatDSN joins options into a key/value connection string
+func formatDSN(opts map[string]string) string {
+	keys := make([]string, 0, len(opts))
+	for k := range opts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var kvs []string
+	escaper := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	for _, k := range keys {
+		kvs = append(kvs, fmt.Sprintf("%s='%s'", k, escaper.Replace(opts[k])))
+	}
+	return strings.Join(kvs, " ")
+}
+
+// lookupPassFile finds the password for the connection options in a pgpass file
+func lookupPassFile(path string, opts map[string]string) (string, error) {
+	if len(path) == 0 {
+		return "", nil
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	host := opts["host"]
+	if len(host) == 0 || strings.HasPrefix(host, "/") {
+		host = "localhost"
+	}
+	port := opts["port"]
+	if len(port) == 0 {
+		port = "5432"
+	}
+	fields := []string{host, port, opts["dbname"], opts["user"]}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entry := splitPassFileLine(line)
+		if len(entry) != 5 {
+			continue
+		}
+		matched := true
+		for i, f := range fields {
+			if entry[i] != "*" && entry[i] != f {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return entry[4], nil
+		}
+	}
+	return "", scanner.Err()
+}
+
+// splitPassFileLine splits a pgpass line on unescaped colons
+func splitPassFileLine(line string) []string {
+	var entry []string
+	var field strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line):
+			i++
+			field.WriteByte(line[i])
+		case line[i] == ':':
+			entry = append(entry, field.String())
+			field.Reset()
+		default:
+			field.WriteByte(line[i])
+		}
+	}
+	return append(entry, field.String())
+}
+
+// redactDSN hides the password of a URL or key/value connection string
+func redactDSN(dsn string) string {
+	dsn = urlPasswordRe.ReplaceAllString(dsn, "${1}"+redacted+"@")
+	return passwordOptionRe.ReplaceAllString(dsn, "${1}"+redacted)
+}
+
+// redactedError hides credentials in the message of the wrapped error
+type redactedError struct {
+	err error
+	msg string
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// redactError replaces the connection string and its password in the error message
+func redactError(err error, dsn string) error {
+	msg := err.Error()
+	if len(dsn) != 0 {
+		msg = strings.Replace(msg, dsn, redactDSN(dsn), -1)
+	}
+	if opts, parseErr := parseDSN(dsn); parseErr == nil && len(opts["password"]) != 0 {
+		msg = strings.Replace(msg, opts["password"], redacted, -1)
+	}
+	if u, parseErr := url.Parse(dsn); parseErr == nil && u.User != nil {
+		if password, ok := u.User.Password(); ok && len(password) != 0 {
+			msg = strings.Replace(msg, password, redacted, -1)
+			msg = strings.Replace(msg, url.QueryEscape(password), redacted, -1)
+		}
+	}
+	msg = redactDSN(msg)
+	return &redactedError{err: err, msg: msg}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"gopkg.in/yaml.v2"
+)
+
+// Exporter owns the collectors built from the current config and swaps them on reload
+type Exporter struct {
+	configFile string
+	// web settings and constant labels of the exporter metrics are taken from the first load
+	loaded      bool
+	web         WebConfig
+	constLabels map[string]string
+	rw          sync.Mutex
+
+	// current *prometheus.Registry with the target collectors
+	registry   atomic.Value
+	collectors []*Collector
+	// names of the target groups with peer totals, map[string]bool
+	peerTotals atomic.Value
+	// syslog receivers by listen address, kept across reloads to keep the socket bound
+	syslog map[string]*syslogReceiver
+
+	lastReloadSuccessful  prometheus.Gauge
+	lastReloadSuccessTime prometheus.Gauge
+}
+
+func NewExporter(configFile string) *Exporter {
+	e := &Exporter{
+		configFile:            configFile,
+		lastReloadSuccessful:  prometheus.NewGauge(buildGaugeOpts(InternalMetricConfigLastReloadSuccessful)),
+		lastReloadSuccessTime: prometheus.NewGauge(buildGaugeOpts(InternalMetricConfigLastReloadSuccessTime)),
+	}
+	e.registry.Store(prometheus.NewRegistry())
+	e.peerTotals.Store(map[string]bool{})
+	return e
+}
+
+// Gather implements prometheus.Gatherer for the current set of collectors
+func (e *Exporter) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := e.registry.Load().(*prometheus.Registry).Gather()
+	if groups := e.peerTotals.Load().(map[string]bool); len(groups) != 0 {
+		addPeerTotals(mfs, groups, metricAggregates())
+	}
+	return mfs, err
+}
+
+// Describe implements prometheus.Collector for the reload metrics
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- e.lastReloadSuccessful.Desc()
+	ch <- e.lastReloadSuccessTime.Desc()
+}
+
+// Collect implements prometheus.Collector for the reload metrics
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	ch <- e.lastReloadSuccessful
+	ch <- e.lastReloadSuccessTime
+}
+
+// Reload reads the config file, builds new collectors and swaps them with the current ones
+func (e *Exporter) Reload() error {
+	e.rw.Lock()
+	defer e.rw.Unlock()
+
+	cfg, err := LoadConfig(e.configFile)
+	if err == nil {
+		err = e.apply(cfg)
+	}
+	if err != nil {
+		e.lastReloadSuccessful.Set(0)
+		return err
+	}
+	e.lastReloadSuccessful.Set(1)
+	e.lastReloadSuccessTime.Set(cast2Float64(time.Now(), 1))
+	return nil
+}
+
+// ConstLabels returns the constant labels of the first successfully loaded config
+func (e *Exporter) ConstLabels() prometheus.Labels {
+	e.rw.Lock()
+	defer e.rw.Unlock()
+	return e.constLabels
+}
+
+// Web returns the web settings of the first successfully loaded config
+func (e *Exporter) Web() WebConfig {
+	e.rw.Lock()
+	defer e.rw.Unlock()
+	return e.web
+}
+
+func (e *Exporter) apply(cfg *Config) error {
+	registry := prometheus.NewRegistry()
+	var collectors, created []*Collector
+
+	// Collectors of unchanged targets are kept with their counters, rates, restart tracking and log position
+	unchanged := make(map[string]*Collector)
+	for _, c := range e.collectors {
+		unchanged[c.configKey] = c
+	}
+
+	closeAll := func() {
+		for _, c := range created {
+			c.Close()
+		}
+	}
+
+	peerTotals := make(map[string]bool)
+	for _, group := range cfg.Targets {
+		if group.PeerTotals {
+			peerTotals[group.Name] = true
+		}
+		for _, t := range group.expand() {
+			key, err := collectorKey(t, cfg)
+			if err != nil {
+				closeAll()
+				return fmt.Errorf("target %q: %v", t.displayName(), err)
+			}
+			collector := unchanged[key]
+			if collector != nil {
+				delete(unchanged, key)
+			} else {
+				db, err := connect(t)
+				if err != nil {
+					closeAll()
+					return fmt.Errorf("target %q: %v", t.displayName(), err)
+				}
+				collector = NewCollector(db, namespace, t, cfg)
+				collector.configKey = key
+				created = append(created, collector)
+			}
+			collectors = append(collectors, collector)
+
+			labels := prometheus.Labels{}
+			for k, v := range cfg.ConstLabels {
+				labels[k] = v
+			}
+			if len(t.Name) != 0 {
+				labels["target"] = t.Name
+			}
+			if len(t.peerID) != 0 {
+				labels[peerLabel] = t.peerID
+			}
+			registerer := prometheus.WrapRegistererWith(labels, registry)
+			if err = registerer.Register(collector); err != nil {
+				closeAll()
+				return fmt.Errorf("target %q: %v", t.displayName(), err)
+			}
+		}
+	}
+
+	if !e.loaded {
+		e.loaded = true
+		e.web = cfg.Web
+		e.constLabels = cfg.ConstLabels
+	} else {
+		if e.web != cfg.Web {
+			logger.Warn("Web settings changed, restart the exporter to apply them")
+		}
+		if !reflect.DeepEqual(e.constLabels, cfg.ConstLabels) {
+			logger.Warn("Constant labels changed, the exporter metrics keep the old ones until a restart")
+		}
+	}
+
+	receivers, err := e.bindSyslog(collectors)
+	if err != nil {
+		closeAll()
+		return err
+	}
+
+	e.collectors = collectors
+	e.registry.Store(registry)
+	e.peerTotals.Store(peerTotals)
+	for _, c := range collectors {
+		if r := receivers[c.syslog.Listen]; r != nil {
+			r.update(c.syslog.Forward, c.logEvents.observe)
+		}
+	}
+	for _, c := range unchanged {
+		c.Close()
+	}
+	for listen, r := range e.syslog {
+		if receivers[listen] == nil {
+			r.Close()
+		}
+	}
+	e.syslog = receivers
+	return nil
+}
+
+// collectorKey identifies the settings a collector is built from, the web settings and
+// the other targets do not change it
+func collectorKey(t TargetConfig, cfg *Config) (string, error) {
+	shared := *cfg
+	shared.Web = WebConfig{}
+	shared.Targets = nil
+	out, err := yaml.Marshal(struct {
+		Target TargetConfig
+		PeerID string
+		Config Config
+	}{t, t.peerID, shared})
+	return string(out), err
+}
+
+// bindSyslog returns the receivers of the collectors, reusing the current ones and binding new listen addresses
+func (e *Exporter) bindSyslog(collectors []*Collector) (map[string]*syslogReceiver, error) {
+	receivers := make(map[string]*syslogReceiver)
+	for _, c := range collectors {
+		listen := c.syslog.Listen
+		if len(listen) == 0 {
+			continue
+		}
+		if r := e.syslog[listen]; r != nil {
+			receivers[listen] = r
+			continue
+		}
+		r, err := newSyslogReceiver(listen, logger)
+		if err != nil {
+			for listen, r := range receivers {
+				if e.syslog[listen] == nil {
+					r.Close()
+				}
+			}
+			return nil, fmt.Errorf("syslog %s: %v", listen, err)
+		}
+		receivers[listen] = r
+	}
+	return receivers, nil
+}
+
+// Close closes the connections of the current collectors
+func (e *Exporter) Close() {
+	e.rw.Lock()
+	defer e.rw.Unlock()
+	for _, c := range e.collectors {
+		c.Close()
+	}
+	e.collectors = nil
+	for _, r := range e.syslog {
+		r.Close()
+	}
+	e.syslog = nil
+}
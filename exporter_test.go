@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// closed reports whether the connection of the collector was closed
+func closed(c *Collector) bool {
+	err := c.db.PingContext(context.Background())
+	return err != nil && err.Error() == "sql: database is closed"
+}
+
+func TestExporterReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	e := NewExporter(path)
+	defer e.Close()
+	reload := func(config string) error {
+		t.Helper()
+		// The flags that default the config are not parsed in tests
+		defaults := "limits: {overflow: aggregate}\nvalues: {on_parse_error: skip}\n"
+		if err := os.WriteFile(path, []byte(defaults+config), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return e.Reload()
+	}
+
+	if err := reload(`
+targets:
+  - name: a
+    socket_dir: /nonexistent/a
+  - name: b
+    socket_dir: /nonexistent/b
+`); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.collectors) != 2 {
+		t.Fatalf("got %d collectors, want 2", len(e.collectors))
+	}
+	a, b := e.collectors[0], e.collectors[1]
+
+	// An unchanged target keeps its collector across a web settings change, a changed one gets a new
+	// collector and the old one is closed
+	if err := reload(`
+web:
+  max_requests: 3
+targets:
+  - name: a
+    socket_dir: /nonexistent/a
+  - name: b
+    socket_dir: /nonexistent/b
+    port: 6433
+`); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.collectors) != 2 || e.collectors[0] != a || e.collectors[1] == b {
+		t.Fatalf("got collectors %p %p, want %p and a new one", e.collectors[0], e.collectors[1], a)
+	}
+	if closed(a) || !closed(b) {
+		t.Errorf("got closed %v %v, want the changed collector closed only", closed(a), closed(b))
+	}
+	changed := e.collectors[1]
+
+	// A config change shared by every target replaces all collectors
+	if err := reload(`
+collectors: [stats, pools]
+targets:
+  - name: a
+    socket_dir: /nonexistent/a
+  - name: b
+    socket_dir: /nonexistent/b
+    port: 6433
+`); err != nil {
+		t.Fatal(err)
+	}
+	if e.collectors[0] == a || e.collectors[1] == changed || !closed(a) || !closed(changed) {
+		t.Fatalf("collectors were kept across a shared config change")
+	}
+	a = e.collectors[0]
+
+	// A removed target's collector is closed
+	removed := e.collectors[1]
+	if err := reload(`
+collectors: [stats, pools]
+targets:
+  - name: a
+    socket_dir: /nonexistent/a
+`); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.collectors) != 1 || e.collectors[0] != a || closed(a) || !closed(removed) {
+		t.Errorf("got %d collectors, want the removed one closed and the other kept", len(e.collectors))
+	}
+
+	// An invalid config leaves the current collectors in place
+	for _, config := range []string{
+		"targets: [",
+		"collectors: [unknown]\n",
+		"targets:\n  - name: a\n    socket_dir: /nonexistent/a\n  - name: a\n    socket_dir: /nonexistent/c\n",
+	} {
+		if err := reload(config); err == nil {
+			t.Errorf("%q: reload succeeded", config)
+		}
+		if len(e.collectors) != 1 || e.collectors[0] != a || closed(a) {
+			t.Errorf("%q: the collectors changed after a failed reload", config)
+		}
+	}
+	var pb dto.Metric
+	if err := e.lastReloadSuccessful.Write(&pb); err != nil {
+		t.Fatal(err)
+	}
+	if got := pb.GetGauge().GetValue(); got != 0 {
+		t.Errorf("last reload successful = %v, want 0", got)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// fakeResult is the output of an admin console command, in the format of readFixture
+type fakeResult struct {
+	columns []string
+	rows    [][]interface{}
+}
+
+// fakePgbouncer answers the admin console commands with fixed results, other commands fail
+type fakePgbouncer struct {
+	mu      sync.Mutex
+	results map[string]fakeResult
+}
+
+// set replaces the result of a command
+func (f *fakePgbouncer) set(query string, columns []string, rows [][]interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.results == nil {
+		f.results = make(map[string]fakeResult)
+	}
+	f.results[query] = fakeResult{columns: columns, rows: rows}
+}
+
+func (f *fakePgbouncer) Connect(context.Context) (driver.Conn, error) {
+	return fakeConn{f}, nil
+}
+
+func (f *fakePgbouncer) Driver() driver.Driver {
+	return nil
+}
+
+type fakeConn struct {
+	pgbouncer *fakePgbouncer
+}
+
+func (c fakeConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	c.pgbouncer.mu.Lock()
+	defer c.pgbouncer.mu.Unlock()
+	result, ok := c.pgbouncer.results[query]
+	if !ok {
+		return nil, fmt.Errorf("unsupported command %q", query)
+	}
+	return &fakeRows{result: result}, nil
+}
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("prepared statements are not supported")
+}
+
+func (c fakeConn) Close() error {
+	return nil
+}
+
+func (c fakeConn) Begin() (driver.Tx, error) {
+	return nil, fmt.Errorf("transactions are not supported")
+}
+
+type fakeRows struct {
+	result fakeResult
+	next   int
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.result.columns
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.result.rows) {
+		return io.EOF
+	}
+	for i, v := range r.result.rows[r.next] {
+		dest[i] = v
+	}
+	r.next++
+	return nil
+}
+
+// newFakeCollector returns a collector of the target scraping the fake PgBouncer, named in the pgbouncer namespace
+func newFakeCollector(t *testing.T, pgbouncer *fakePgbouncer, target TargetConfig, cfg *Config) *Collector {
+	t.Helper()
+	defer func(ns string) { namespace = ns }(namespace)
+	namespace = "pgbouncer"
+	c := NewCollector(sql.OpenDB(pgbouncer), "pgbouncer", target, cfg)
+	t.Cleanup(c.Close)
+	return c
+}
+
+// gatherSeries collects the collector once with the constant labels, like the exporter registers
+// the collector of a target, and returns the series by metric name and labels
+func gatherSeries(t *testing.T, labels prometheus.Labels, collector prometheus.Collector) map[string]*dto.Metric {
+	t.Helper()
+	r := prometheus.NewRegistry()
+	if err := prometheus.WrapRegistererWith(labels, r).Register(collector); err != nil {
+		t.Fatal(err)
+	}
+	mfs, err := r.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	series := make(map[string]*dto.Metric)
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			series[seriesName(mf.GetName(), m)] = m
+		}
+	}
+	return series
+}
+
+// seriesName formats the series like the text format, with the labels in name order
+func seriesName(name string, m *dto.Metric) string {
+	if len(m.GetLabel()) == 0 {
+		return name
+	}
+	name += "{"
+	for i, l := range m.GetLabel() {
+		if i > 0 {
+			name += ","
+		}
+		name += fmt.Sprintf("%s=%q", l.GetName(), l.GetValue())
+	}
+	return name + "}"
+}
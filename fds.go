@@ -0,0 +1,102 @@
+package main
+
+import (
+	"math"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/procfs"
+)
+
+// fdTasks maps the task column of SHOW FDS to the task label, pooler is a listening socket
+var fdTasks = map[string]string{
+	"pooler": "listener",
+	"client": "client",
+	"server": "server",
+}
+
+// countFds counts the SHOW FDS rows by task, tasks without sockets are reported as 0
+func countFds(metricGroup *MetricGroup, rows []Row) []prometheus.Metric {
+	counts := map[string]float64{}
+	for _, row := range rows {
+		if task, ok := fdTasks[cast2string(row["task"])]; ok {
+			counts[task]++
+		}
+	}
+
+	metricDesc := metricGroup.Metrics["open"]
+	if metricDesc == nil {
+		return nil
+	}
+	var metrics []prometheus.Metric
+	for _, task := range []string{"listener", "client", "server"} {
+		if !matchRowFilters(metricGroup, []string{task}) {
+			continue
+		}
+		metrics = append(metrics, prometheus.MustNewConstMetric(&metricDesc.Desc, metricDesc.Type, counts[task], task))
+	}
+	return metrics
+}
+
+// deriveFdMetrics compares the sockets of SHOW FDS and the most sockets PgBouncer may need, max_client_conn
+// plus pool_size and reserve_pool of every pool, with the RLIMIT_NOFILE of the process found by the scrape
+func (c *Collector) deriveFdMetrics(results map[string][]Row, proc *procfs.Proc) []prometheus.Metric {
+	var metrics []prometheus.Metric
+	emit := func(name string, value float64) {
+		metricDesc := c.derivedFds.Metrics[name]
+		if metricDesc == nil || math.IsNaN(value) || math.IsInf(value, 0) {
+			return
+		}
+		metrics = append(metrics, prometheus.MustNewConstMetric(&metricDesc.Desc, metricDesc.Type, value))
+	}
+
+	// The limit is only known for a local process
+	limit := math.NaN()
+	if c.process != nil && proc != nil {
+		if maxFds, err := c.process.maxFds(*proc); err == nil {
+			limit = maxFds
+		} else {
+			c.logger.Debug("Failed to read file descriptor limit", "err", err)
+		}
+	}
+
+	fds, haveFds := results[MetricDescriptorFds.Prefix]
+	if haveFds {
+		emit("fd_headroom", limit-float64(len(fds)))
+	}
+
+	pools, havePools := results[MetricDescriptorPools.Prefix]
+	databases, haveDatabases := results[MetricDescriptorDatabases.Prefix]
+	config, haveConfig := results[MetricDescriptorConfig.Prefix]
+	if !havePools || !haveDatabases || !haveConfig {
+		return metrics
+	}
+
+	required := math.NaN()
+	for _, row := range config {
+		if cast2string(row["key"]) == "max_client_conn" {
+			required = cast2Float64(row["value"], 1)
+		}
+	}
+	databaseRows := make(map[string]Row)
+	for _, row := range databases {
+		databaseRows[cast2string(row["name"])] = row
+	}
+	// Every pool, a database and user pair, may open pool_size plus reserve_pool server connections
+	for _, row := range pools {
+		if databaseRow, ok := databaseRows[cast2string(row["database"])]; ok {
+			for _, column := range []string{"pool_size", "reserve_pool"} {
+				if v := cast2Float64(databaseRow[column], 1); !math.IsNaN(v) {
+					required += v
+				}
+			}
+		}
+	}
+	for _, row := range fds {
+		if cast2string(row["task"]) == "pooler" {
+			required++
+		}
+	}
+	emit("fd_required", required)
+	emit("fd_limit_headroom", limit-required)
+	return metrics
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestCountFds(t *testing.T) {
+	fds := readFixtureRows(t, filepath.Join("testdata", "show-fds", "pgbouncer-1.21.txt"))
+	tests := []struct {
+		rows    []Row
+		filters []*LabelFilter
+		want    map[string]float64
+	}{
+		{rows: fds, want: map[string]float64{"listener": 2, "client": 3, "server": 2}},
+		// Tasks without sockets are reported as 0
+		{rows: fds[:2], want: map[string]float64{"listener": 2, "client": 0, "server": 0}},
+		{rows: nil, want: map[string]float64{"listener": 0, "client": 0, "server": 0}},
+		{
+			rows:    fds,
+			filters: []*LabelFilter{{Label: "task", Exclude: "listener"}},
+			want:    map[string]float64{"client": 3, "server": 2},
+		},
+	}
+
+	for _, tt := range tests {
+		for _, f := range tt.filters {
+			if err := f.compile(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		metricGroup := attachFilters(buildMetricGroup(MetricDescriptorFds, nil), tt.filters)
+		got := make(map[string]float64)
+		for _, m := range countFds(metricGroup, tt.rows) {
+			var pb dto.Metric
+			if err := m.Write(&pb); err != nil {
+				t.Fatal(err)
+			}
+			got[pb.GetLabel()[0].GetValue()] = pb.GetGauge().GetValue()
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%d rows: got %v, want %v", len(tt.rows), got, tt.want)
+		}
+		for task, v := range tt.want {
+			if value, ok := got[task]; !ok || value != v {
+				t.Errorf("%d rows: %s = %v, want %v", len(tt.rows), task, value, v)
+			}
+		}
+	}
+}
+
+func TestDeriveFdMetrics(t *testing.T) {
+	all := map[string][]Row{
+		MetricDescriptorFds.Prefix:       readFixtureRows(t, filepath.Join("testdata", "show-fds", "pgbouncer-1.21.txt")),
+		MetricDescriptorPools.Prefix:     readFixtureRows(t, filepath.Join("testdata", "show-pools", "pgbouncer-1.21.txt")),
+		MetricDescriptorDatabases.Prefix: readFixtureRows(t, filepath.Join("testdata", "show-databases", "pgbouncer-1.21.txt")),
+		MetricDescriptorConfig.Prefix:    {{"key": []byte("max_client_conn"), "value": []byte("100")}},
+	}
+	without := func(prefix string) map[string][]Row {
+		results := make(map[string][]Row)
+		for k, v := range all {
+			if k != prefix {
+				results[k] = v
+			}
+		}
+		return results
+	}
+
+	// max_client_conn 100, pool_size 4 and reserve_pool 2 of the two app pools, pool_size 2 of the
+	// admin console and its 2 listeners need 116 sockets, the fixture process has a limit of 1024
+	tests := []struct {
+		name    string
+		results map[string][]Row
+		process bool
+		want    map[string]float64
+	}{
+		{
+			name: "all", results: all, process: true,
+			want: map[string]float64{"fd_headroom": 1017, "fd_required": 116, "fd_limit_headroom": 908},
+		},
+		{
+			name: "without process", results: all,
+			want: map[string]float64{"fd_required": 116},
+		},
+		{
+			name: "without fds", results: without(MetricDescriptorFds.Prefix), process: true,
+			want: map[string]float64{"fd_required": 114, "fd_limit_headroom": 910},
+		},
+		{
+			name: "without config", results: without(MetricDescriptorConfig.Prefix), process: true,
+			want: map[string]float64{"fd_headroom": 1017},
+		},
+		{
+			name: "without pools", results: without(MetricDescriptorPools.Prefix), process: true,
+			want: map[string]float64{"fd_headroom": 1017},
+		},
+		{
+			name: "without databases", results: without(MetricDescriptorDatabases.Prefix), process: true,
+			want: map[string]float64{"fd_headroom": 1017},
+		},
+		{
+			name: "max_client_conn missing", process: true,
+			results: map[string][]Row{
+				MetricDescriptorPools.Prefix:     all[MetricDescriptorPools.Prefix],
+				MetricDescriptorDatabases.Prefix: all[MetricDescriptorDatabases.Prefix],
+				MetricDescriptorConfig.Prefix:    {},
+			},
+			want: map[string]float64{},
+		},
+		{name: "nothing", results: map[string][]Row{}, process: true, want: map[string]float64{}},
+	}
+
+	for _, tt := range tests {
+		c := newDerivedCollector()
+		var metrics []prometheus.Metric
+		if tt.process {
+			c.process = newFixtureProcessCollector(t, ProcessConfig{Name: "pgbouncer"})
+			proc, err := c.process.find()
+			if err != nil {
+				t.Fatal(err)
+			}
+			metrics = c.deriveFdMetrics(tt.results, &proc)
+		} else {
+			metrics = c.deriveFdMetrics(tt.results, nil)
+		}
+		got := derivedValues(t, c, metrics)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+		for name, v := range tt.want {
+			if value, ok := got[name]; !ok || value != v {
+				t.Errorf("%s: %s = %v, want %v", tt.name, name, value, v)
+			}
+		}
+	}
+}
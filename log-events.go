@@ -0,0 +1,156 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	logReasonOther = "other"
+
+	defaultLogMaxSeries = 1000
+)
+
+var (
+	// logLevelRe finds the level after the optional timestamp and [pid] of a log or syslog line
+	logLevelRe = regexp.MustCompile(`(?:^|\s)(LOG|WARNING|ERROR|FATAL) (.*)$`)
+	// logConnRe splits the C-0x.../S-0x... prefix of a client or server connection message
+	logConnRe = regexp.MustCompile(`^[CS]-0x[0-9a-fA-F]+: ([^/\s]*)/([^@\s]*)@\S+ (.*)$`)
+)
+
+// logPatterns map the detail of a message to a fixed reason, the first match wins
+var logPatterns = []struct {
+	reason string
+	re     *regexp.Regexp
+}{
+	{"query_wait_timeout", regexp.MustCompile(`query_wait_timeout`)},
+	{"query_timeout", regexp.MustCompile(`query_timeout`)},
+	{"client_idle_timeout", regexp.MustCompile(`client_idle_timeout`)},
+	{"client_login_timeout", regexp.MustCompile(`client_login_timeout`)},
+	{"idle_transaction_timeout", regexp.MustCompile(`idle_transaction_timeout|idle transaction timeout`)},
+	{"server_idle_timeout", regexp.MustCompile(`server idle timeout`)},
+	{"server_lifetime", regexp.MustCompile(`server lifetime over`)},
+	{"server_connect_failed", regexp.MustCompile(`connect failed|server_connect_timeout|connect timeout`)},
+	{"server_login_failing", regexp.MustCompile(`server login has been failing`)},
+	{"server_conn_crashed", regexp.MustCompile(`server conn crashed`)},
+	{"client_close_request", regexp.MustCompile(`client close request`)},
+	{"client_unexpected_eof", regexp.MustCompile(`client unexpected eof`)},
+	{"login_failed", regexp.MustCompile(`(?i)password authentication failed|auth failed|login failed|no such user|SASL authentication failed`)},
+	{"no_such_database", regexp.MustCompile(`no such database`)},
+	{"no_more_connections", regexp.MustCompile(`no more connections allowed`)},
+	{"database_changed", regexp.MustCompile(`database configuration changed|database removed`)},
+	{"accept_failed", regexp.MustCompile(`accept\(\) failed`)},
+	{"tls_error", regexp.MustCompile(`(?i)\btls\b|\bssl\b`)},
+}
+
+// logEvent is a disconnect or error parsed from a PgBouncer log line
+type logEvent struct {
+	reason   string
+	database string
+	user     string
+}
+
+// parseLogLine returns the event of a "closing because", "pooler error", warning or error line,
+// other LOG lines are no events
+func parseLogLine(line string) (logEvent, bool) {
+	m := logLevelRe.FindStringSubmatch(strings.TrimRight(line, "\r\n"))
+	if m == nil {
+		return logEvent{}, false
+	}
+	level, msg := m[1], m[2]
+
+	var event logEvent
+	if c := logConnRe.FindStringSubmatch(msg); c != nil {
+		event.database, event.user, msg = connName(c[1], "(nodb)"), connName(c[2], "(nouser)"), c[3]
+	}
+
+	detail, isClose := cutAfter(msg, "closing because: ")
+	if isClose {
+		// The connection age is not part of the reason
+		if i := strings.LastIndex(detail, " (age="); i >= 0 {
+			detail = detail[:i]
+		}
+	} else if d, isError := cutAfter(msg, "pooler error: "); isError {
+		detail = d
+	} else if level == "LOG" {
+		return logEvent{}, false
+	}
+
+	event.reason = logReasonOther
+	for _, p := range logPatterns {
+		if p.re.MatchString(detail) {
+			event.reason = p.reason
+			break
+		}
+	}
+	return event, true
+}
+
+// connName returns the database or user of a connection prefix, PgBouncer logs missing names as placeholders
+func connName(name, placeholder string) string {
+	if name == placeholder {
+		return ""
+	}
+	return name
+}
+
+func cutAfter(s, sep string) (string, bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[i+len(sep):], true
+	}
+	return s, false
+}
+
+// logEvents counts the events of PgBouncer log lines by reason, database and user
+type logEvents struct {
+	metricGroup *MetricGroup
+	maxSeries   int
+	events      *prometheus.CounterVec
+	lines       prometheus.Counter
+
+	mu sync.Mutex
+	// label sets counted so far, others go to database and user "other" above maxSeries
+	series map[logEvent]bool
+}
+
+func newLogEvents(maxSeries int, filters []*LabelFilter) *logEvents {
+	if maxSeries <= 0 {
+		maxSeries = defaultLogMaxSeries
+	}
+	metricGroup := attachFilters(buildMetricGroup(MetricDescriptorLogEvents, nil), filters)
+	return &logEvents{
+		metricGroup: metricGroup,
+		maxSeries:   maxSeries,
+		events:      prometheus.NewCounterVec(buildCounterOpts(MetricLogEvents), metricGroup.Labels),
+		lines:       prometheus.NewCounter(buildCounterOpts(MetricLogLines)),
+		series:      make(map[logEvent]bool),
+	}
+}
+
+// observe counts the event of a line, client supplied names of failed logins are capped by maxSeries
+func (e *logEvents) observe(line string) {
+	e.lines.Inc()
+	event, ok := parseLogLine(line)
+	if !ok || !matchRowFilters(e.metricGroup, []string{event.reason, event.database, event.user}) {
+		return
+	}
+
+	e.mu.Lock()
+	if !e.series[event] {
+		if len(e.series) >= e.maxSeries {
+			event.database, event.user = overflowLabel, overflowLabel
+		}
+		e.series[event] = true
+	}
+	e.mu.Unlock()
+	e.events.WithLabelValues(event.reason, event.database, event.user).Inc()
+}
+
+// Collect sends the counters
+func (e *logEvents) Collect(ch chan<- prometheus.Metric) {
+	ch <- e.lines
+	e.events.Collect(ch)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestParseLogLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want logEvent
+		ok   bool
+	}{
+		{
+			line: "2023-11-20 16:02:52.101 UTC [1] LOG C-0x7f9c3a8e1e70: app/alice@172.18.0.5:45822 closing because: client close request (age=11s)",
+			want: logEvent{reason: "client_close_request", database: "app", user: "alice"}, ok: true,
+		},
+		{
+			line: "2023-11-20 16:03:05.642 UTC [1] WARNING C-0x7f9c3a8e2180: app/mallory@172.18.0.9:50102 pooler error: password authentication failed",
+			want: logEvent{reason: "login_failed", database: "app", user: "mallory"}, ok: true,
+		},
+		{
+			line: "2023-11-20 16:03:12.007 UTC [1] LOG C-0x7f9c3a8e2490: (nodb)/(nouser)@172.18.0.9:50110 closing because: SSL required (age=0s)",
+			want: logEvent{reason: "tls_error"}, ok: true,
+		},
+		{
+			line: "2023-11-20 16:06:02.118 UTC [1] LOG C-0x7f9c3a8e30d0: app/carol@172.18.0.7:46000 closing because: no more connections allowed (max_client_conn) (age=0s)",
+			want: logEvent{reason: "no_more_connections", database: "app", user: "carol"}, ok: true,
+		},
+		{
+			line: "2023-11-20 16:09:00.000 UTC [1] LOG S-0x7f9c3a8f2750: app/bob@172.18.0.3:5432 closing because: server_connect_timeout (age=15s)",
+			want: logEvent{reason: "server_connect_failed", database: "app", user: "bob"}, ok: true,
+		},
+		{
+			line: "2020-03-11 09:25:01.511 UTC [2714] LOG S-0x55f3e0c22d88: app/bob@10.0.0.10:5432 closing because: server login has been failing, try again later (server_login_retry) (age=0s)",
+			want: logEvent{reason: "server_login_failing", database: "app", user: "bob"}, ok: true,
+		},
+		{
+			line: "2020-03-11 09:26:44.090 UTC [2714] WARNING C-0x55f3e0c1a980: app/bob@10.0.0.6:52000 pooler error: query_wait_timeout\r\n",
+			want: logEvent{reason: "query_wait_timeout", database: "app", user: "bob"}, ok: true,
+		},
+		{
+			line: "2020-03-11 09:30:12.338 UTC [2714] ERROR accept() failed: Too many open files",
+			want: logEvent{reason: "accept_failed"}, ok: true,
+		},
+		{
+			line: "2023-11-20 16:12:30.000 UTC [1] LOG S-0x7f9c3a8f2d70: app/bob@172.18.0.3:5432 closing because: something new (age=1s)",
+			want: logEvent{reason: logReasonOther, database: "app", user: "bob"}, ok: true,
+		},
+		// Syslog lines carry the level after the ident
+		{
+			line: "<29>Nov 20 16:04:18 db1 pgbouncer[812]: LOG C-0x7f9c3a8e27a0: app/bob@172.18.0.6:45900 closing because: client_idle_timeout (age=300s)",
+			want: logEvent{reason: "client_idle_timeout", database: "app", user: "bob"}, ok: true,
+		},
+		{line: "2023-11-20 16:02:40.817 UTC [1] LOG C-0x7f9c3a8e1e70: app/alice@172.18.0.5:45822 login attempt: db=app user=alice tls=no"},
+		{line: "2023-11-20 16:03:31.555 UTC [1] LOG stats: 3 xacts/s, 9 queries/s, in 1340 B/s, out 5127 B/s"},
+		{line: "not a log line"},
+		{line: ""},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseLogLine(tt.line)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseLogLine(%q) = %+v, %v, want %+v, %v", tt.line, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseLogLineFixtures(t *testing.T) {
+	tests := map[string]map[string]int{
+		"pgbouncer-1.12.log": {
+			"client_close_request": 1, "login_failed": 2, "client_unexpected_eof": 1, "no_such_database": 2,
+			"server_idle_timeout": 1, "server_connect_failed": 1, "server_login_failing": 1, "query_wait_timeout": 2,
+			"accept_failed": 1, "database_changed": 1,
+		},
+		"pgbouncer-1.21.log": {
+			"client_close_request": 1, "login_failed": 2, "tls_error": 3, "client_idle_timeout": 1,
+			"idle_transaction_timeout": 2, "query_timeout": 2, "no_more_connections": 2, "server_lifetime": 1,
+			"server_conn_crashed": 1, "server_connect_failed": 1, "client_login_timeout": 1, "database_changed": 1,
+			logReasonOther: 1,
+		},
+	}
+
+	for file, want := range tests {
+		f, err := os.Open(filepath.Join("testdata", "pgbouncer-log", file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[string]int)
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if event, ok := parseLogLine(scanner.Text()); ok {
+				got[event.reason]++
+			}
+		}
+		f.Close()
+		if err = scanner.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(want) {
+			t.Errorf("%s: got reasons %v, want %v", file, got, want)
+		}
+		for reason, n := range want {
+			if got[reason] != n {
+				t.Errorf("%s: got %d %s events, want %d", file, got[reason], reason, n)
+			}
+		}
+	}
+}
+
+func TestLogEventsFoldsOverMaxSeries(t *testing.T) {
+	events := newLogEvents(2, nil)
+	line := "2023-11-20 16:03:05.642 UTC [1] WARNING C-0x7f9c3a8e2180: app/%s@172.18.0.9:50102 pooler error: password authentication failed"
+	for _, user := range []string{"alice", "bob", "alice", "mallory", "eve", "bob"} {
+		events.observe(fmt.Sprintf(line, user))
+	}
+
+	want := map[[2]string]float64{
+		{"app", "alice"}:               2,
+		{"app", "bob"}:                 2,
+		{overflowLabel, overflowLabel}: 2,
+	}
+	ch := make(chan prometheus.Metric, 10)
+	events.events.Collect(ch)
+	close(ch)
+	got := make(map[[2]string]float64)
+	for m := range ch {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		labels := make(map[string]string)
+		for _, l := range pb.GetLabel() {
+			labels[l.GetName()] = l.GetValue()
+		}
+		if labels["reason"] != "login_failed" {
+			t.Errorf("got reason %q, want login_failed", labels["reason"])
+		}
+		got[[2]string{labels["database"], labels["user"]}] = pb.GetCounter().GetValue()
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for series, v := range want {
+		if got[series] != v {
+			t.Errorf("%v = %v, want %v", series, got[series], v)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+const (
+	defaultLogPollInterval = time.Second
+	// maxLogLine bounds the partial line kept while waiting for its newline
+	maxLogLine = 64 * 1024
+)
+
+// LogConfig follows the PgBouncer log file of a target, an empty file disables it
+type LogConfig struct {
+	File         string        `yaml:"file"`
+	PollInterval time.Duration `yaml:"poll_interval"`
+	MaxSeries    int           `yaml:"max_series"`
+}
+
+// logTailer polls the log file for new lines like tail -F, the first open starts at the end
+// of the file, a rotated or truncated file is read from the start
+type logTailer struct {
+	logger   *slog.Logger
+	path     string
+	interval time.Duration
+	observe  func(line string)
+
+	file    *os.File
+	info    os.FileInfo
+	offset  int64
+	partial []byte
+	opened  bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newLogTailer(cfg LogConfig, logger *slog.Logger, observe func(line string)) *logTailer {
+	interval := cfg.PollInterval
+	if interval <= 0 {
+		interval = defaultLogPollInterval
+	}
+	t := &logTailer{
+		logger:   logger.With("file", cfg.File),
+		path:     cfg.File,
+		interval: interval,
+		observe:  observe,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+func (t *logTailer) run() {
+	defer close(t.done)
+	defer t.close()
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		t.poll()
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll reads the new lines, then reopens the path if the file was rotated or truncated
+func (t *logTailer) poll() {
+	if t.file == nil {
+		if err := t.open(); err != nil {
+			// A log file created after the start is read from the beginning
+			t.opened = t.opened || os.IsNotExist(err)
+			t.logger.Warn("Failed to open pgbouncer log", "err", err)
+			return
+		}
+	}
+	t.read()
+
+	info, err := os.Stat(t.path)
+	switch {
+	case err != nil:
+		// Rotated away and not created yet, the old file may still get lines
+	case !os.SameFile(info, t.info):
+		t.flush()
+		t.close()
+		if err := t.open(); err != nil {
+			t.logger.Warn("Failed to open rotated pgbouncer log", "err", err)
+			return
+		}
+		t.read()
+	case info.Size() < t.offset:
+		t.logger.Info("Pgbouncer log was truncated, reading from the start")
+		t.partial = t.partial[:0]
+		if _, err := t.file.Seek(0, io.SeekStart); err != nil {
+			t.logger.Warn("Failed to seek pgbouncer log", "err", err)
+			t.close()
+			return
+		}
+		t.offset = 0
+		t.read()
+	}
+}
+
+func (t *logTailer) open() error {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	// Lines written before the exporter started are not counted
+	offset := int64(0)
+	if !t.opened {
+		if offset, err = f.Seek(0, io.SeekEnd); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	t.file, t.info, t.offset, t.opened = f, info, offset, true
+	t.partial = t.partial[:0]
+	return nil
+}
+
+// read passes the complete lines up to the end of the file to observe
+func (t *logTailer) read() {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := t.file.Read(buf)
+		t.offset += int64(n)
+		t.partial = append(t.partial, buf[:n]...)
+		for {
+			i := bytes.IndexByte(t.partial, '\n')
+			if i < 0 {
+				break
+			}
+			t.observe(string(t.partial[:i]))
+			t.partial = t.partial[i+1:]
+		}
+		if len(t.partial) > maxLogLine {
+			t.flush()
+		}
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			t.logger.Warn("Failed to read pgbouncer log", "err", err)
+			return
+		}
+	}
+}
+
+// flush passes a last line without newline, e.g. of a rotated file
+func (t *logTailer) flush() {
+	if len(t.partial) != 0 {
+		t.observe(string(t.partial))
+		t.partial = t.partial[:0]
+	}
+}
+
+func (t *logTailer) close() {
+	if t.file != nil {
+		t.file.Close()
+		t.file = nil
+	}
+}
+
+// Close stops following the file
+func (t *logTailer) Close() {
+	close(t.stop)
+	<-t.done
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// appendLog appends lines to the log file, creating it if needed
+func appendLog(t *testing.T, path string, lines string) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.WriteString(lines); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLogTailerRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pgbouncer.log")
+	appendLog(t, path, "before start\n")
+
+	var lines []string
+	// The tailer is polled by the test instead of its goroutine
+	tailer := &logTailer{logger: slog.Default(), path: path, observe: func(line string) { lines = append(lines, line) }}
+	defer tailer.close()
+	check := func(step string, want ...string) {
+		t.Helper()
+		tailer.poll()
+		if !slices.Equal(lines, want) {
+			t.Errorf("%s: got %q, want %q", step, lines, want)
+		}
+		lines = nil
+	}
+
+	// Lines written before the start are not read, a partial line waits for its newline
+	check("start at end")
+	appendLog(t, path, "first\nsec")
+	check("append", "first")
+	appendLog(t, path, "ond\n")
+	check("complete line", "second")
+
+	// Rename: the rest of the old file and its last line without newline, then the new file from its start
+	appendLog(t, path, "old tail\nno newline")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	check("renamed away", "old tail")
+	appendLog(t, path, "new file\n")
+	check("rename", "no newline", "new file")
+
+	// Copytruncate: the file is read again from its start
+	appendLog(t, path, "copied\n")
+	check("before truncate", "copied")
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	appendLog(t, path, "after\n")
+	check("copytruncate", "after")
+}
+
+func TestLogTailerReadsLateFileFromStart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pgbouncer.log")
+	var lines []string
+	tailer := &logTailer{logger: slog.Default(), path: path, observe: func(line string) { lines = append(lines, line) }}
+	defer tailer.close()
+
+	tailer.poll()
+	appendLog(t, path, "first\n")
+	tailer.poll()
+	if !slices.Equal(lines, []string{"first"}) {
+		t.Errorf("got %q, want the lines of a file created after the start", lines)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxRateLimitEntries = 1000
+
+// logger is the exporter logger, replaced by setupLogger on startup
+var logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+// setupLogger builds the logger from the -log.* flags
+func setupLogger(w io.Writer, level, format string, repeatInterval time.Duration) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	var handler slog.Handler
+	switch format {
+	case "logfmt":
+		handler = slog.NewTextHandler(w, opts)
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("invalid log format %q, expected logfmt or json", format)
+	}
+
+	if repeatInterval > 0 {
+		handler = &rateLimitHandler{
+			Handler: handler,
+			state: &rateLimitState{
+				interval: repeatInterval,
+				entries:  make(map[string]*rateLimitEntry),
+			},
+		}
+	}
+	logger = slog.New(handler)
+	return nil
+}
+
+// rateLimitHandler drops warnings and errors identical to one logged less than interval ago,
+// the next logged copy reports how many were suppressed
+type rateLimitHandler struct {
+	slog.Handler
+	attrs string
+	state *rateLimitState
+}
+
+type rateLimitState struct {
+	mu       sync.Mutex
+	interval time.Duration
+	entries  map[string]*rateLimitEntry
+}
+
+type rateLimitEntry struct {
+	last       time.Time
+	suppressed int
+}
+
+func (h *rateLimitHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < slog.LevelWarn {
+		return h.Handler.Handle(ctx, r)
+	}
+
+	key := h.recordKey(r)
+	suppressed, ok := h.state.allow(key, r.Time)
+	if !ok {
+		return nil
+	}
+	if suppressed > 0 {
+		r = r.Clone()
+		r.AddAttrs(slog.Int("suppressed", suppressed))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *rateLimitHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &rateLimitHandler{
+		Handler: h.Handler.WithAttrs(attrs),
+		attrs:   h.attrs + formatAttrs(attrs),
+		state:   h.state,
+	}
+}
+
+func (h *rateLimitHandler) WithGroup(name string) slog.Handler {
+	return &rateLimitHandler{
+		Handler: h.Handler.WithGroup(name),
+		attrs:   h.attrs + name + ".",
+		state:   h.state,
+	}
+}
+
+// recordKey identifies repeated records, duration changes on every scrape and is ignored
+func (h *rateLimitHandler) recordKey(r slog.Record) string {
+	var attrs []slog.Attr
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key != "duration" {
+			attrs = append(attrs, a)
+		}
+		return true
+	})
+	return h.attrs + r.Level.String() + " " + r.Message + " " + formatAttrs(attrs)
+}
+
+// allow reports whether the record may be logged and how many copies were suppressed before it
+func (s *rateLimitState) allow(key string, now time.Time) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if ok && now.Sub(entry.last) < s.interval {
+		entry.suppressed++
+		return 0, false
+	}
+	if !ok {
+		if len(s.entries) >= maxRateLimitEntries {
+			s.prune(now)
+		}
+		if len(s.entries) >= maxRateLimitEntries {
+			s.evictOldest()
+		}
+		entry = &rateLimitEntry{}
+		s.entries[key] = entry
+	}
+	suppressed := entry.suppressed
+	entry.last = now
+	entry.suppressed = 0
+	return suppressed, true
+}
+
+// prune deletes the entries logged at least interval ago, their next record is logged anyway
+func (s *rateLimitState) prune(now time.Time) {
+	for key, entry := range s.entries {
+		if now.Sub(entry.last) >= s.interval {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// evictOldest deletes the entry logged longest ago when every entry is still within interval,
+// the count of its suppressed copies is lost
+func (s *rateLimitState) evictOldest() {
+	var oldestKey string
+	var oldest time.Time
+	for key, entry := range s.entries {
+		if len(oldestKey) == 0 || entry.last.Before(oldest) {
+			oldestKey, oldest = key, entry.last
+		}
+	}
+	delete(s.entries, oldestKey)
+}
+
+func formatAttrs(attrs []slog.Attr) string {
+	parts := make([]string, 0, len(attrs))
+	for _, a := range attrs {
+		parts = append(parts, a.String())
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newRateLimitHandler returns a rate limiting logfmt handler writing to buf
+func newRateLimitHandler(buf *bytes.Buffer, interval time.Duration) *rateLimitHandler {
+	return &rateLimitHandler{
+		Handler: slog.NewTextHandler(buf, nil),
+		state:   &rateLimitState{interval: interval, entries: make(map[string]*rateLimitEntry)},
+	}
+}
+
+// handle logs a record at the time
+func handle(t *testing.T, h slog.Handler, now time.Time, level slog.Level, msg string, attrs ...slog.Attr) {
+	t.Helper()
+	r := slog.NewRecord(now, level, msg, 0)
+	r.AddAttrs(attrs...)
+	if err := h.Handle(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRateLimitHandler(t *testing.T) {
+	var buf bytes.Buffer
+	h := newRateLimitHandler(&buf, time.Minute)
+	now := time.Unix(1700000000, 0)
+
+	// Repeated warnings within the interval are dropped, the duration does not make them differ
+	for i := 0; i < 3; i++ {
+		handle(t, h, now.Add(time.Duration(i)*time.Second), slog.LevelWarn, "Scrape failed",
+			slog.String("err", "timeout"), slog.Duration("duration", time.Duration(i)*time.Millisecond))
+	}
+	// Other attributes, other handler attributes and records below warn are not limited
+	handle(t, h, now, slog.LevelWarn, "Scrape failed", slog.String("err", "refused"))
+	handle(t, h.WithAttrs([]slog.Attr{slog.String("target", "b")}), now, slog.LevelWarn, "Scrape failed", slog.String("err", "timeout"))
+	handle(t, h, now, slog.LevelInfo, "Reloaded")
+	handle(t, h, now, slog.LevelInfo, "Reloaded")
+	if got := strings.Count(buf.String(), "\n"); got != 5 {
+		t.Errorf("got %d lines, want 5\n%s", got, buf.String())
+	}
+	if strings.Contains(buf.String(), "suppressed") {
+		t.Errorf("unexpected summary\n%s", buf.String())
+	}
+
+	// The next copy after the interval reports the suppressed ones
+	buf.Reset()
+	handle(t, h, now.Add(time.Minute), slog.LevelWarn, "Scrape failed", slog.String("err", "timeout"))
+	if !strings.Contains(buf.String(), `msg="Scrape failed" err=timeout suppressed=2`) {
+		t.Errorf("missing the summary of 2 suppressed copies\n%s", buf.String())
+	}
+	buf.Reset()
+	handle(t, h, now.Add(2*time.Minute), slog.LevelWarn, "Scrape failed", slog.String("err", "timeout"))
+	if buf.Len() == 0 || strings.Contains(buf.String(), "suppressed") {
+		t.Errorf("got %q, want the record without a summary", buf.String())
+	}
+}
+
+func TestRateLimitHandlerCap(t *testing.T) {
+	var buf bytes.Buffer
+	h := newRateLimitHandler(&buf, time.Hour)
+	now := time.Unix(1700000000, 0)
+
+	// Distinct records within the interval keep the entries at the cap by evicting the oldest
+	for i := 0; i < maxRateLimitEntries+10; i++ {
+		handle(t, h, now.Add(time.Duration(i)*time.Millisecond), slog.LevelError, "Query failed", slog.Int("pool", i))
+	}
+	if got := len(h.state.entries); got != maxRateLimitEntries {
+		t.Errorf("got %d entries, want the cap %d", got, maxRateLimitEntries)
+	}
+
+	// The evicted record is logged again, the newest ones are still limited
+	buf.Reset()
+	later := now.Add(time.Second)
+	handle(t, h, later, slog.LevelError, "Query failed", slog.Int("pool", 0))
+	handle(t, h, later, slog.LevelError, "Query failed", slog.Int("pool", maxRateLimitEntries+9))
+	if got := buf.String(); strings.Count(got, "\n") != 1 || !strings.Contains(got, "pool=0") {
+		t.Errorf("got %q, want only the evicted record", got)
+	}
+	if got := len(h.state.entries); got != maxRateLimitEntries {
+		t.Errorf("got %d entries, want the cap %d", got, maxRateLimitEntries)
+	}
+
+	// Expired entries are pruned before the oldest is evicted
+	handle(t, h, now.Add(2*time.Hour), slog.LevelError, "Reload failed")
+	if got := len(h.state.entries); got != 1 {
+		t.Errorf("got %d entries after the interval, want 1", got)
+	}
+}
@@ -4,81 +4,275 @@ import (
 	"database/sql"
 	"fmt"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/common/log"
+	"github.com/prometheus/procfs"
+	"log/slog"
 	"sync"
 	"time"
 )
 
-const MaxErrors = 5
-
 type MetricGroup struct {
-	Labels  []string
-	Metrics map[string]*MetricDesc
+	// Labels are the label columns, OutLabels the label names after relabeling
+	Labels        []string
+	OutLabels     []string
+	Relabel       *RelabelConfig
+	Metrics       map[string]*MetricDesc
+	Filters       []*LabelFilter
+	DatabaseLabel string
+	FilteredRows  prometheus.Counter
+	Values        ValueConfig
+	ParseErrors   *prometheus.CounterVec
+}
+
+// value converts a column value, counting failures; false if the series is skipped.
+// Values with a unit are already in seconds or bytes, factor converts plain numbers.
+func (g *MetricGroup) value(column string, data interface{}, factor float64) (float64, bool) {
+	v, hasUnit, err := parseValue(data)
+	if err == nil {
+		if hasUnit {
+			return v, true
+		}
+		return v * factor, true
+	}
+	if g.ParseErrors != nil {
+		g.ParseErrors.WithLabelValues(column).Inc()
+	}
+	if g.Values.OnParseError == parseErrorDefault {
+		return g.Values.Default, true
+	}
+	return 0, false
+}
+
+// databaseOutLabel returns the name of the label with the pgbouncer database after relabeling,
+// empty if the group has none or it is dropped
+func (g *MetricGroup) databaseOutLabel() string {
+	label := g.DatabaseLabel
+	if len(label) == 0 {
+		label = "database"
+	}
+	if !contains(g.Labels, label) {
+		return ""
+	}
+	if out := g.Relabel.apply([]string{label}); len(out) != 0 {
+		return out[0]
+	}
+	return ""
 }
 
 type MetricDesc struct {
-	Type   prometheus.ValueType
-	Desc   prometheus.Desc
-	Factor float64
+	Type         prometheus.ValueType
+	Desc         prometheus.Desc
+	Factor       float64
+	Aggregate    string
+	MicrosColumn string
+	SourceUnit   string
+	Default      float64
 }
 
 type Collector struct {
-	db *sql.DB
-	rw sync.Mutex
+	db     *sql.DB
+	rw     sync.Mutex
+	logger *slog.Logger
+	// configKey identifies the settings the collector was built from, see collectorKey
+	configKey string
 
 	// internal state
-	up             prometheus.Gauge
-	errors         prometheus.Gauge
-	scrapeLastTime prometheus.Gauge
-	totalScrapes   prometheus.Counter
+	up               prometheus.Gauge
+	errors           prometheus.Gauge
+	scrapeLastTime   prometheus.Gauge
+	totalScrapes     prometheus.Counter
+	connectionErrors *prometheus.CounterVec
+	filteredRows     *prometheus.CounterVec
+	parseErrors      *prometheus.CounterVec
+	seriesDropped    *prometheus.CounterVec
+
+	// client certificate, re-read when modified to follow rotation
+	clientCert       *certExpiry
+	clientCertExpiry prometheus.Gauge
 
 	// metrics
-	namespace            string
-	metricGroupLists     *MetricGroup
-	metricGroupStats     *MetricGroup
-	metricGroupPools     *MetricGroup
-	metricGroupDatabases *MetricGroup
-	metricGroupConfig    *MetricGroup
-}
-
-func NewCollector(db *sql.DB, namespace string) *Collector {
-	return &Collector{
-		db:                   db,
-		namespace:            namespace,
-		up:                   prometheus.NewGauge(buildGaugeOpts(InternalMetricUp)),
-		errors:               prometheus.NewGauge(buildGaugeOpts(InternalMetricErrors)),
-		scrapeLastTime:       prometheus.NewGauge(buildGaugeOpts(InternalMetricScrapeLastTime)),
-		totalScrapes:         prometheus.NewCounter(buildCounterOpts(InternalMetricScrapeTotal)),
-		metricGroupLists:     buildMetricGroup(MetricDescriptorLists),
-		metricGroupStats:     buildMetricGroup(MetricDescriptorStats),
-		metricGroupPools:     buildMetricGroup(MetricDescriptorPools),
-		metricGroupDatabases: buildMetricGroup(MetricDescriptorDatabases),
-		metricGroupConfig:    buildMetricGroup(MetricDescriptorConfig),
+	namespace        string
+	guard            *seriesGuard
+	scrapeGroups     []*ScrapeGroup
+	derivedPools     *MetricGroup
+	derivedDatabases *MetricGroup
+	derivedClients   *MetricGroup
+	derivedFds       *MetricGroup
+
+	// PgBouncer start time detected from the STATS counters
+	startTracker     *startTracker
+	startTime        *prometheus.GaugeVec
+	restartsDetected prometheus.Counter
+
+	// wait time histogram of SHOW CLIENTS, nil if disabled
+	clientWait *clientWait
+	// SHOW POOLS sampler between scrapes, nil if disabled
+	sampler *poolSampler
+	// rates of the STATS counters between scrapes, nil if disabled
+	statsRates *statsRates
+	// resource usage of the local PgBouncer process, nil if disabled
+	process *processCollector
+	// events of the PgBouncer log file or syslog, nil if both are disabled
+	logEvents *logEvents
+	logTailer *logTailer
+	// syslog receiver settings, the receiver is owned by the exporter
+	syslog SyslogConfig
+	// pg_stat_activity of the PostgreSQL servers, nil if disabled
+	backend *backendCollector
+}
+
+// ScrapeGroup binds an admin console command to the metric group it feeds.
+type ScrapeGroup struct {
+	Name        string
+	Query       string
+	MetricGroup *MetricGroup
+	InfoGroup   *MetricGroup
+	ExtractFunc ExtractFunc
+}
+
+// metricGroups returns the groups of all metrics the command produces
+func (g *ScrapeGroup) metricGroups() []*MetricGroup {
+	if g.InfoGroup != nil {
+		return []*MetricGroup{g.MetricGroup, g.InfoGroup}
+	}
+	return []*MetricGroup{g.MetricGroup}
+}
+
+// ScrapeDefinition describes a collector that can be enabled in the config file.
+type ScrapeDefinition struct {
+	Query       string
+	Descriptor  MetricDescriptor
+	ExtractFunc ExtractFunc
+	// Optional collectors are only scraped when listed in collectors
+	Optional bool
+}
+
+// ScrapeDefinitions lists the available collectors in scrape order.
+var ScrapeDefinitions = []ScrapeDefinition{
+	{Query: "SHOW LISTS;", Descriptor: MetricDescriptorLists, ExtractFunc: extractKeyValue},
+	{Query: "SHOW STATS;", Descriptor: MetricDescriptorStats, ExtractFunc: extractRow},
+	{Query: "SHOW POOLS;", Descriptor: MetricDescriptorPools, ExtractFunc: extractRow},
+	{Query: "SHOW DATABASES;", Descriptor: MetricDescriptorDatabases, ExtractFunc: extractRow},
+	{Query: "SHOW CONFIG;", Descriptor: MetricDescriptorConfig, ExtractFunc: extractNone},
+	{Query: "SHOW PEERS;", Descriptor: MetricDescriptorPeers, ExtractFunc: extractRow, Optional: true},
+	{Query: "SHOW FDS;", Descriptor: MetricDescriptorFds, ExtractFunc: extractNone, Optional: true},
+}
+
+func NewCollector(db *sql.DB, namespace string, target TargetConfig, cfg *Config) *Collector {
+	collectors := cfg.Collectors
+	if len(target.Collectors) != 0 {
+		collectors = target.Collectors
+	}
+	limits := cfg.Limits
+	if target.Limits != nil {
+		limits = *target.Limits
+	}
+
+	filteredRows := prometheus.NewCounterVec(buildCounterOpts(InternalMetricFilteredRows), []string{"collector"})
+	parseErrors := prometheus.NewCounterVec(buildCounterOpts(InternalMetricValueParseErrors), []string{"collector", "column"})
+
+	var scrapeGroups []*ScrapeGroup
+	for _, def := range ScrapeDefinitions {
+		if len(collectors) != 0 && !contains(collectors, def.Descriptor.Prefix) || len(collectors) == 0 && def.Optional {
+			continue
+		}
+		relabel := cfg.Relabel[def.Descriptor.Prefix]
+		g := &ScrapeGroup{
+			Name:        def.Descriptor.Prefix,
+			Query:       def.Query,
+			ExtractFunc: def.ExtractFunc,
+		}
+		descriptor := def.Descriptor
+		// Move the descriptive DATABASES labels to an info metric, so a changed host keeps the series
+		if cfg.DatabaseInfoMetric && descriptor.Prefix == MetricDescriptorDatabases.Prefix {
+			descriptor = MetricDescriptorDatabasesByName
+			g.InfoGroup = attachFilters(buildMetricGroup(MetricDescriptorDatabaseInfo, relabel), cfg.LabelFilters)
+			g.ExtractFunc = extractRowWithInfo(g.InfoGroup)
+		}
+		g.MetricGroup = attachFilters(buildMetricGroup(descriptor, relabel), cfg.LabelFilters)
+		g.MetricGroup.FilteredRows = filteredRows.WithLabelValues(def.Descriptor.Prefix)
+		g.MetricGroup.Values = cfg.Values
+		g.MetricGroup.ParseErrors = parseErrors.MustCurryWith(prometheus.Labels{"collector": def.Descriptor.Prefix})
+		scrapeGroups = append(scrapeGroups, g)
+	}
+
+	c := &Collector{
+		db:             db,
+		logger:         logger.With("target", target.displayName()),
+		namespace:      namespace,
+		up:             prometheus.NewGauge(buildGaugeOpts(InternalMetricUp)),
+		errors:         prometheus.NewGauge(buildGaugeOpts(InternalMetricErrors)),
+		scrapeLastTime: prometheus.NewGauge(buildGaugeOpts(InternalMetricScrapeLastTime)),
+		totalScrapes:   prometheus.NewCounter(buildCounterOpts(InternalMetricScrapeTotal)),
+		connectionErrors: prometheus.NewCounterVec(
+			buildCounterOpts(InternalMetricConnectionErrors), []string{"reason"},
+		),
+		filteredRows: filteredRows,
+		parseErrors:  parseErrors,
+		seriesDropped: prometheus.NewCounterVec(
+			buildCounterOpts(InternalMetricSeriesDropped), []string{"collector"},
+		),
+		scrapeGroups:     scrapeGroups,
+		derivedPools:     attachFilters(buildMetricGroup(MetricDescriptorDerivedPools, nil), cfg.LabelFilters),
+		derivedDatabases: attachFilters(buildMetricGroup(MetricDescriptorDerivedDatabases, nil), cfg.LabelFilters),
+		derivedClients:   buildMetricGroup(MetricDescriptorDerivedClients, nil),
+		derivedFds:       buildMetricGroup(MetricDescriptorDerivedFds, nil),
+		startTracker:     newStartTracker(),
+		startTime:        prometheus.NewGaugeVec(buildGaugeOpts(InternalMetricStartTime), []string{"source"}),
+		restartsDetected: prometheus.NewCounter(buildCounterOpts(InternalMetricRestartsDetected)),
+	}
+	c.guard = newSeriesGuard(limits, c.seriesDropped)
+	if len(target.TLS.Cert) != 0 {
+		c.clientCert = &certExpiry{path: target.TLS.Cert}
+		c.clientCertExpiry = prometheus.NewGauge(buildGaugeOpts(InternalMetricClientCertExpiry))
+	}
+	if cfg.ClientWaitHistogram {
+		c.clientWait = newClientWait(cfg.LabelFilters)
+	}
+	if target.Process.enabled() {
+		c.process = newProcessCollector(target.Process)
+	}
+	if len(target.Log.File) != 0 || len(target.Syslog.Listen) != 0 {
+		c.logEvents = newLogEvents(target.Log.MaxSeries, cfg.LabelFilters)
+		c.syslog = target.Syslog
+	}
+	if len(target.Log.File) != 0 {
+		c.logTailer = newLogTailer(target.Log, c.logger, c.logEvents.observe)
+	}
+	if target.Backend.enabled() {
+		c.backend = newBackendCollector(target.Backend, c.logger, cfg.LabelFilters)
+	}
+	if cfg.StatsRates {
+		c.statsRates = newStatsRates(cfg.LabelFilters)
 	}
+	if cfg.Sampler.Interval > 0 {
+		c.sampler = newPoolSampler(cfg.Sampler, c.logger, cfg.LabelFilters, func() ([]Row, error) {
+			_, rows, err := c.extractMetrics("SHOW POOLS;", nil, extractNone)
+			return rows, err
+		})
+	}
+	return c
 }
 
 func (c *Collector) Close() {
+	if c.sampler != nil {
+		c.sampler.Close()
+	}
+	if c.logTailer != nil {
+		c.logTailer.Close()
+	}
 	c.rw.Lock()
 	defer c.rw.Unlock()
+	if c.backend != nil {
+		c.backend.Close()
+	}
 	if err := c.db.Close(); err != nil {
-		log.Error(err)
+		c.logger.Error("Failed to close connection", "err", err)
 	}
 }
 
-func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
-	metricCh := make(chan prometheus.Metric)
-	doneCh := make(chan struct{})
-
-	go func() {
-		for m := range metricCh {
-			ch <- m.Desc()
-		}
-		close(doneCh)
-	}()
-
-	c.Collect(metricCh)
-	close(metricCh)
-	<-doneCh
+// Describe sends no descriptors, the collector is unchecked: describing would need a scrape,
+// which would disturb the sampler window, rates and restart tracking of kept collectors on every reload
+func (c *Collector) Describe(chan<- *prometheus.Desc) {
 }
 
 func (c *Collector) Collect(ch chan<- prometheus.Metric) {
@@ -87,6 +281,22 @@ func (c *Collector) Collect(ch chan<- prometheus.Metric) {
 	ch <- c.errors
 	ch <- c.scrapeLastTime
 	ch <- c.totalScrapes
+	c.connectionErrors.Collect(ch)
+	c.filteredRows.Collect(ch)
+	c.parseErrors.Collect(ch)
+	c.seriesDropped.Collect(ch)
+	ch <- c.restartsDetected
+	if start, source := c.startTracker.start(); !start.IsZero() {
+		c.startTime.Reset()
+		c.startTime.WithLabelValues(source).Set(cast2Float64(start, 1))
+		c.startTime.Collect(ch)
+	}
+	if c.clientCertExpiry != nil {
+		ch <- c.clientCertExpiry
+	}
+	if c.logEvents != nil {
+		c.logEvents.Collect(ch)
+	}
 }
 
 func (c *Collector) scrape(ch chan<- prometheus.Metric) {
@@ -94,42 +304,136 @@ func (c *Collector) scrape(ch chan<- prometheus.Metric) {
 	defer c.rw.Unlock()
 
 	errors := 0
+	failedGroups := 0
+	connected := true
 	c.up.Set(1)
 	c.scrapeLastTime.Set(cast2Float64(time.Now(), 1))
 	c.totalScrapes.Inc()
 
-	metrics, err := c.extractMetrics("SHOW LISTS;", c.metricGroupLists, extractKeyValue)
-	if err = c.handleExtractedMetrics(ch, metrics, err); err != nil {
-		log.Error("Failed to extract metrics LISTS: ", err)
-		errors++
+	// The process is looked up once for the start time, the fd limit and its metrics
+	var proc *procfs.Proc
+	var procErr error
+	if c.process != nil {
+		var p procfs.Proc
+		if p, procErr = c.process.find(); procErr == nil {
+			proc = &p
+			if start, err := c.process.startTime(p); err == nil {
+				c.startTracker.set(start, startSourceProcess)
+			}
+		}
+	}
+
+	guard := c.guard
+	guard.begin()
+	results := make(map[string][]Row)
+	for _, g := range c.scrapeGroups {
+		start := time.Now()
+		metrics, rows, err := c.extractMetrics(g.Query, g.MetricGroup, g.ExtractFunc)
+		if err == nil {
+			if g.Name == MetricDescriptorFds.Prefix {
+				metrics = countFds(g.MetricGroup, rows)
+			}
+			if g.Name == MetricDescriptorConfig.Prefix {
+				metrics = extractConfig(g.MetricGroup, rows)
+			}
+			if g.MetricGroup.Relabel.mergesSeries() {
+				metrics = mergeDuplicates(g.metricGroups(), metrics)
+			}
+			metrics = guard.apply(g.Name, g.metricGroups(), metrics)
+			if g.Name == MetricDescriptorStats.Prefix {
+				if c.startTracker.observe(start, g.MetricGroup, rows) {
+					startTime, source := c.startTracker.start()
+					c.logger.Warn("Detected pgbouncer restart, STATS counters went down", "start_time", startTime, "source", source)
+					c.restartsDetected.Inc()
+				}
+				// Counters get a created timestamp only once the start time is known, never the first scrape
+				if startTime, _ := c.startTracker.start(); !startTime.IsZero() {
+					metrics = withCreatedTimestamp(metrics, startTime)
+				}
+				if c.statsRates != nil {
+					rates := c.statsRates.observe(start, rows)
+					metrics = append(metrics, guard.apply(MetricDescriptorStatsRates.Prefix, []*MetricGroup{c.statsRates.metricGroup}, rates)...)
+				}
+			}
+		}
+		if err = c.handleExtractedMetrics(ch, metrics, err); err != nil {
+			errors++
+			failedGroups++
+			duration := time.Since(start)
+			// The remaining queries would fail the same way
+			if reason := connectionErrorReason(err); len(reason) != 0 {
+				c.logger.Error("Failed to connect to pgbouncer", "collector", g.Name, "duration", duration,
+					"reason", reason, "hint", connectionErrorHint(reason), "err", err)
+				c.connectionErrors.WithLabelValues(reason).Inc()
+				connected = false
+				break
+			}
+			c.logger.Error("Failed to extract metrics", "collector", g.Name, "duration", duration, "err", err)
+			continue
+		}
+		results[g.Name] = rows
+	}
+
+	if connected {
+		derivedGroups := []*MetricGroup{c.derivedPools, c.derivedDatabases, c.derivedClients, c.derivedFds}
+		metrics := append(c.deriveMetrics(results), c.deriveFdMetrics(results, proc)...)
+		metrics = guard.apply(derivedCollector, derivedGroups, metrics)
+		_ = c.handleExtractedMetrics(ch, metrics, nil)
 	}
 
-	metrics, err = c.extractMetrics("SHOW STATS;", c.metricGroupStats, extractRow)
-	if err = c.handleExtractedMetrics(ch, metrics, err); err != nil {
-		log.Error("Failed to extract metrics STATS: ", err)
-		errors++
+	if c.sampler != nil {
+		metrics := guard.apply(MetricDescriptorPoolSamples.Prefix, []*MetricGroup{c.sampler.metricGroup}, c.sampler.flush())
+		_ = c.handleExtractedMetrics(ch, metrics, nil)
 	}
 
-	metrics, err = c.extractMetrics("SHOW POOLS;", c.metricGroupPools, extractRow)
-	if err = c.handleExtractedMetrics(ch, metrics, err); err != nil {
-		log.Error("Failed to extract metrics POOLS: ", err)
-		errors++
+	pools, havePools := results[MetricDescriptorPools.Prefix]
+	databases, haveDatabases := results[MetricDescriptorDatabases.Prefix]
+	if c.backend != nil && havePools && haveDatabases {
+		metrics, failed := c.backend.metrics(pools, databases)
+		errors += failed
+		metrics = guard.apply(backendCollectorName, c.backend.metricGroups(), metrics)
+		_ = c.handleExtractedMetrics(ch, metrics, nil)
 	}
 
-	metrics, err = c.extractMetrics("SHOW DATABASES;", c.metricGroupDatabases, extractRow)
-	if err = c.handleExtractedMetrics(ch, metrics, err); err != nil {
-		log.Error("Failed to extract metrics DATABASES: ", err)
-		errors++
+	if connected && c.clientWait != nil {
+		start := time.Now()
+		if _, rows, err := c.extractMetrics("SHOW CLIENTS;", c.clientWait.metricGroup, extractNone); err != nil {
+			c.logger.Error("Failed to extract client wait times", "duration", time.Since(start), "err", err)
+			errors++
+		} else {
+			c.clientWait.observe(start, rows)
+		}
+	}
+	if c.clientWait != nil {
+		metrics := guard.apply(MetricDescriptorClients.Prefix, []*MetricGroup{c.clientWait.metricGroup}, collectMetrics(c.clientWait.histogram))
+		_ = c.handleExtractedMetrics(ch, metrics, nil)
 	}
 
-	metrics, err = c.extractMetrics("SHOW CONFIG;", c.metricGroupConfig, extractKeyValue)
-	if err = c.handleExtractedMetrics(ch, metrics, err); err != nil {
-		log.Error("Failed to extract metrics CONFIG: ", err)
-		errors++
+	if c.clientCertExpiry != nil {
+		expiry, err := c.clientCert.read()
+		if err != nil {
+			c.logger.Error("Failed to read client certificate", "file", c.clientCert.path, "err", err)
+			errors++
+		} else {
+			c.clientCertExpiry.Set(cast2Float64(expiry, 1))
+		}
+	}
+
+	if c.process != nil {
+		var metrics []prometheus.Metric
+		err := procErr
+		if proc != nil {
+			metrics, err = c.process.metrics(*proc)
+		}
+		if err != nil {
+			c.logger.Error("Failed to read pgbouncer process", "err", err)
+			errors++
+		}
+		_ = c.handleExtractedMetrics(ch, metrics, nil)
 	}
 
 	c.errors.Set(float64(errors))
-	if errors >= MaxErrors {
+	if !connected || failedGroups >= len(c.scrapeGroups) {
 		c.up.Set(0)
 	}
 }
@@ -144,10 +448,27 @@ func (c *Collector) handleExtractedMetrics(ch chan<- prometheus.Metric, metrics
 	return nil
 }
 
-func (c *Collector) extractMetrics(query string, metricGroup *MetricGroup, extractFunc ExtractFunc) ([]prometheus.Metric, error) {
+// collectMetrics returns the metrics of a collector, so a vector can pass the series guard
+func collectMetrics(collector prometheus.Collector) []prometheus.Metric {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		collector.Collect(ch)
+		close(ch)
+	}()
+	var metrics []prometheus.Metric
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+// Row is a scraped row by column name, kept for the metrics derived from several commands
+type Row map[string]interface{}
+
+func (c *Collector) extractMetrics(query string, metricGroup *MetricGroup, extractFunc ExtractFunc) ([]prometheus.Metric, []Row, error) {
 	rows, err := c.db.Query(query)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer rows.Close()
 
@@ -161,40 +482,89 @@ func (c *Collector) extractMetrics(query string, metricGroup *MetricGroup, extra
 	}
 
 	var resultMetrics []prometheus.Metric
+	var resultRows []Row
 	for rows.Next() {
 		if err = rows.Scan(scanArgs...); err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		metrics := extractFunc(metricGroup, columns, columnData)
 		resultMetrics = append(resultMetrics, metrics...)
+
+		row := make(Row, nColumn)
+		for i, colName := range columns {
+			row[colName] = columnData[i]
+		}
+		resultRows = append(resultRows, row)
 	}
 
-	return resultMetrics, nil
+	return resultMetrics, resultRows, rows.Err()
 }
 
 type ExtractFunc func(metricGroup *MetricGroup, columns []string, columnData []interface{}) []prometheus.Metric
 
+// extractNone keeps only the rows, for commands that feed stateful metrics
+func extractNone(*MetricGroup, []string, []interface{}) []prometheus.Metric {
+	return nil
+}
+
 func extractKeyValue(metricGroup *MetricGroup, _ []string, columnData []interface{}) []prometheus.Metric {
 	var result []prometheus.Metric
-	metricDesc := metricGroup.Metrics[cast2string(columnData[0])]
+	key := cast2string(columnData[0])
+	metricDesc := metricGroup.Metrics[key]
 	if metricDesc != nil {
-		metricValue := cast2Float64(columnData[1], metricDesc.Factor)
-		result = append(result, prometheus.MustNewConstMetric(&metricDesc.Desc, metricDesc.Type, metricValue))
+		if metricValue, ok := metricGroup.value(key, columnData[1], metricDesc.Factor); ok {
+			result = append(result, prometheus.MustNewConstMetric(&metricDesc.Desc, metricDesc.Type, metricValue))
+		}
 	}
 	return result
 }
 
-func extractRow(metricGroup *MetricGroup, columns []string, columnData []interface{}) []prometheus.Metric {
+// extractConfig converts the SHOW CONFIG rows, the time_usec keys are read in microseconds
+// if the output prints them so
+func extractConfig(metricGroup *MetricGroup, rows []Row) []prometheus.Metric {
 	var result []prometheus.Metric
-	var labelValues []string
+	microseconds := configMicroseconds(metricGroup, rows)
+	for _, row := range rows {
+		key := cast2string(row["key"])
+		metricDesc := metricGroup.Metrics[key]
+		if metricDesc == nil {
+			continue
+		}
+		factor := metricDesc.Factor
+		if microseconds && metricDesc.SourceUnit == sourceTimeUsec {
+			factor = unitFactors["us"]
+		}
+		if metricValue, ok := metricGroup.value(key, row["value"], factor); ok {
+			result = append(result, prometheus.MustNewConstMetric(&metricDesc.Desc, metricDesc.Type, metricValue))
+		}
+	}
+	return result
+}
 
-	// collect labels
-	for i, colName := range columns {
-		if contains(metricGroup.Labels, colName) {
-			labelValues = append(labelValues, cast2string(columnData[i]))
+// configMicroseconds reports whether the output prints the time_usec keys in microseconds: their
+// default column then holds the documented defaults in microseconds. Output without a default
+// column prints seconds.
+func configMicroseconds(metricGroup *MetricGroup, rows []Row) bool {
+	for _, row := range rows {
+		metricDesc := metricGroup.Metrics[cast2string(row["key"])]
+		if metricDesc == nil || metricDesc.SourceUnit != sourceTimeUsec || metricDesc.Default == 0 {
 			continue
 		}
+		if v, hasUnit, err := parseValue(row["default"]); err == nil && !hasUnit {
+			return v == metricDesc.Default*1e6
+		}
+	}
+	return false
+}
+
+func extractRow(metricGroup *MetricGroup, columns []string, columnData []interface{}) []prometheus.Metric {
+	var result []prometheus.Metric
+
+	// collect labels
+	labelValues, ok := collectLabels(metricGroup, columns, columnData)
+	if !ok {
+		return nil
 	}
 	// collect metrics
 	for i, colName := range columns {
@@ -202,31 +572,123 @@ func extractRow(metricGroup *MetricGroup, columns []string, columnData []interfa
 			continue
 		}
 		metricDesc := metricGroup.Metrics[colName]
-		if metricDesc != nil {
-			metricValue := cast2Float64(columnData[i], metricDesc.Factor)
-			result = append(result, prometheus.MustNewConstMetric(&metricDesc.Desc, metricDesc.Type, metricValue, labelValues...))
+		if metricDesc == nil {
+			continue
+		}
+		metricValue, ok := metricGroup.value(colName, columnData[i], metricDesc.Factor)
+		if !ok {
+			continue
+		}
+		if j := indexOf(columns, metricDesc.MicrosColumn); j >= 0 {
+			if us, err := parseFloat64(columnData[j]); err == nil {
+				metricValue += us * 1e-6
+			}
 		}
+		result = append(result, prometheus.MustNewConstMetric(&metricDesc.Desc, metricDesc.Type, metricValue, labelValues...))
 	}
 	return result
 }
 
-func buildMetricGroup(descriptor MetricDescriptor) *MetricGroup {
+// extractRowWithInfo extracts the row and an info metric with the labels of the info group
+func extractRowWithInfo(infoGroup *MetricGroup) ExtractFunc {
+	return func(metricGroup *MetricGroup, columns []string, columnData []interface{}) []prometheus.Metric {
+		result := extractRow(metricGroup, columns, columnData)
+		labelValues, ok := collectLabels(infoGroup, columns, columnData)
+		if !ok {
+			return result
+		}
+		for _, metricDesc := range infoGroup.Metrics {
+			result = append(result, prometheus.MustNewConstMetric(&metricDesc.Desc, metricDesc.Type, 1, labelValues...))
+		}
+		return result
+	}
+}
+
+// collectLabels returns the relabeled label values of the row, false if the row is filtered out
+func collectLabels(metricGroup *MetricGroup, columns []string, columnData []interface{}) ([]string, bool) {
+	labelValues := make([]string, len(metricGroup.Labels))
+	for i, colName := range columns {
+		for j, label := range metricGroup.Labels {
+			if label != colName {
+				continue
+			}
+			labelValue := cast2string(columnData[i])
+			if !matchFilters(metricGroup.Filters, colName, labelValue) {
+				if metricGroup.FilteredRows != nil {
+					metricGroup.FilteredRows.Inc()
+				}
+				return nil, false
+			}
+			labelValues[j] = labelValue
+		}
+	}
+	return metricGroup.Relabel.applyValues(metricGroup.Labels, labelValues), true
+}
+
+// buildMetricGroup builds the descriptors of a metric group, panics if a metric breaks the naming rules
+func buildMetricGroup(descriptor MetricDescriptor, relabel *RelabelConfig) *MetricGroup {
 	m := make(map[string]*MetricDesc)
+	outLabels := relabel.apply(descriptor.Labels)
 
 	for _, v := range descriptor.MetricProps {
-		m[v.Name] = &MetricDesc{
-			Type:   v.Type,
-			Desc:   *prometheus.NewDesc(fmt.Sprintf("%s_%s_%s", namespace, descriptor.Prefix, v.Name), v.Help, descriptor.Labels, nil),
-			Factor: v.Factor,
+		if err := v.validate(); err != nil {
+			panic(fmt.Sprintf("metric descriptor %s: %v", descriptor.Prefix, err))
+		}
+		factor := v.Factor
+		if sourceFactor, ok := unitFactors[v.SourceUnit]; ok {
+			factor = sourceFactor
+		}
+		if factor == 0 {
+			factor = 1
+		}
+		column := v.Column
+		if len(column) == 0 {
+			column = v.Name
+		}
+		m[column] = &MetricDesc{
+			Type:         v.Type,
+			Desc:         *prometheus.NewDesc(fmt.Sprintf("%s_%s_%s", namespace, descriptor.Prefix, v.Name), v.Help, outLabels, nil),
+			Factor:       factor,
+			Aggregate:    v.Aggregate,
+			MicrosColumn: v.MicrosColumn,
+			SourceUnit:   v.SourceUnit,
+			Default:      v.Default,
 		}
 	}
 	return &MetricGroup{
-		Labels:  descriptor.Labels,
-		Metrics: m,
+		Labels:        descriptor.Labels,
+		OutLabels:     outLabels,
+		Relabel:       relabel,
+		Metrics:       m,
+		DatabaseLabel: descriptor.DatabaseLabel,
+	}
+}
+
+// attachFilters adds the label filters that apply to the labels of the group,
+// "database" filters apply to the label holding the pgbouncer database name
+func attachFilters(metricGroup *MetricGroup, filters []*LabelFilter) *MetricGroup {
+	for _, f := range filters {
+		if f.Label == "database" && len(metricGroup.DatabaseLabel) != 0 {
+			databaseFilter := *f
+			databaseFilter.Label = metricGroup.DatabaseLabel
+			f = &databaseFilter
+		}
+		if contains(metricGroup.Labels, f.Label) {
+			metricGroup.Filters = append(metricGroup.Filters, f)
+		}
+	}
+	return metricGroup
+}
+
+// mustValidate panics if an internal metric breaks the naming rules
+func mustValidate(props MetricProps) {
+	if err := props.validate(); err != nil {
+		panic(fmt.Sprintf("internal metric: %v", err))
 	}
 }
 
 func buildGaugeOpts(props MetricProps) prometheus.GaugeOpts {
+	mustValidate(props)
 	return prometheus.GaugeOpts{
 		Namespace: namespace,
 		Name:      props.Name,
@@ -234,7 +696,22 @@ func buildGaugeOpts(props MetricProps) prometheus.GaugeOpts {
 	}
 }
 
+// buildHistogramOpts builds a native histogram that also exposes classic buckets for scrapers without native support
+func buildHistogramOpts(props MetricProps) prometheus.HistogramOpts {
+	mustValidate(props)
+	return prometheus.HistogramOpts{
+		Namespace:                       namespace,
+		Name:                            props.Name,
+		Help:                            props.Help,
+		Buckets:                         []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+		NativeHistogramBucketFactor:     1.1,
+		NativeHistogramMaxBucketNumber:  160,
+		NativeHistogramMinResetDuration: time.Hour,
+	}
+}
+
 func buildCounterOpts(props MetricProps) prometheus.CounterOpts {
+	mustValidate(props)
 	return prometheus.CounterOpts{
 		Namespace: namespace,
 		Name:      props.Name,
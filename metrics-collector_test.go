@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// readFixture reads a SHOW command output saved as a header line of column names and rows,
+// with the columns separated by "|"; values are []byte like the text columns of lib/pq
+func readFixture(t *testing.T, path string) ([]string, [][]interface{}) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var columns []string
+	var rows [][]interface{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "|")
+		if columns == nil {
+			columns = fields
+			continue
+		}
+		row := make([]interface{}, len(fields))
+		for i, field := range fields {
+			row[i] = []byte(field)
+		}
+		rows = append(rows, row)
+	}
+	if err = scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return columns, rows
+}
+
+// readFixtureRows reads a fixture as the rows of a scrape
+func readFixtureRows(t *testing.T, path string) []Row {
+	t.Helper()
+	columns, values := readFixture(t, path)
+	rows := make([]Row, len(values))
+	for i, row := range values {
+		rows[i] = make(Row, len(columns))
+		for j, column := range columns {
+			rows[i][column] = row[j]
+		}
+	}
+	return rows
+}
+
+// configValues returns the CONFIG metrics of the rows by metric name
+func configValues(t *testing.T, columns []string, rows [][]interface{}) map[string]float64 {
+	t.Helper()
+	metricGroup := buildMetricGroup(MetricDescriptorConfig, nil)
+	names := make(map[*prometheus.Desc]string)
+	for _, props := range MetricDescriptorConfig.MetricProps {
+		column := props.Column
+		if len(column) == 0 {
+			column = props.Name
+		}
+		names[&metricGroup.Metrics[column].Desc] = props.Name
+	}
+
+	configRows := make([]Row, len(rows))
+	for i, row := range rows {
+		configRows[i] = make(Row, len(columns))
+		for j, column := range columns {
+			configRows[i][column] = row[j]
+		}
+	}
+	values := make(map[string]float64)
+	for _, m := range extractConfig(metricGroup, configRows) {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		values[names[m.Desc()]] = pb.GetGauge().GetValue()
+	}
+	return values
+}
+
+func TestConfigUnitsByVersion(t *testing.T) {
+	// Values of the default pgbouncer.ini of the fixtures
+	defaults := map[string]float64{
+		"listen_backlog":                   128,
+		"disable_pqexec":                   0,
+		"pkt_buf_bytes":                    4096,
+		"max_client_conn":                  1000,
+		"default_pool_size":                20,
+		"reserve_pool_timeout_seconds":     5,
+		"autodb_idle_timeout_seconds":      3600,
+		"server_check_delay_seconds":       30,
+		"query_timeout_seconds":            0,
+		"query_wait_timeout_seconds":       120,
+		"client_login_timeout_seconds":     60,
+		"server_lifetime_seconds":          3600,
+		"server_idle_timeout_seconds":      600,
+		"server_connect_timeout_seconds":   15,
+		"server_login_retry_seconds":       15,
+		"suspend_timeout_seconds":          10,
+		"dns_max_ttl_seconds":              15,
+		"dns_nxdomain_ttl_seconds":         15,
+		"max_packet_size_bytes":            2147483647,
+		"sbuf_loopcnt":                     5,
+		"tcp_defer_accept_seconds":         45,
+		"tcpkeepalive":                     1,
+		"tcp_keepidle_seconds":             0,
+		"stats_period_seconds":             60,
+		"idle_transaction_timeout_seconds": 0,
+		"log_pooler_errors":                1,
+	}
+	tests := []struct {
+		version string
+		// changed settings of the fixture
+		changed map[string]float64
+		// settings the version does not have
+		missing []string
+	}{
+		{version: "1.12", missing: []string{"tcp_user_timeout_seconds"}},
+		{version: "1.18", changed: map[string]float64{
+			"server_lifetime_seconds":  1800,
+			"query_timeout_seconds":    300,
+			"tcp_user_timeout_seconds": 30,
+			"tcp_keepidle_seconds":     60,
+		}},
+		{version: "1.21", changed: map[string]float64{
+			"reserve_pool_timeout_seconds": 0.5,
+			"server_lifetime_seconds":      1800,
+			"pkt_buf_bytes":                8192,
+			"tcp_user_timeout_seconds":     0,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			columns, rows := readFixture(t, filepath.Join("testdata", "show-config", "pgbouncer-"+tt.version+".txt"))
+			values := configValues(t, columns, rows)
+
+			for _, props := range MetricDescriptorConfig.MetricProps {
+				if _, ok := values[props.Name]; !ok && !slices.Contains(tt.missing, props.Name) {
+					t.Errorf("%s: not exported", props.Name)
+				}
+			}
+			for name, want := range defaults {
+				if changed, ok := tt.changed[name]; ok {
+					want = changed
+				}
+				if got := values[name]; got != want {
+					t.Errorf("%s = %v, want %v", name, got, want)
+				}
+			}
+			for name, want := range tt.changed {
+				if got := values[name]; got != want {
+					t.Errorf("%s = %v, want %v", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestConfigValuesWithUnits(t *testing.T) {
+	columns := []string{"key", "value", "default", "changeable"}
+	rows := [][]interface{}{
+		{[]byte("query_timeout"), []byte("500ms"), []byte("0"), []byte("yes")},
+		{[]byte("query_wait_timeout"), []byte("120000000us"), []byte("120"), []byte("yes")},
+		{[]byte("server_lifetime"), []byte("1h"), []byte("3600"), []byte("yes")},
+		{[]byte("autodb_idle_timeout"), []byte("60min"), []byte("3600"), []byte("yes")},
+		{[]byte("pkt_buf"), []byte("4kB"), []byte("4096"), []byte("no")},
+		{[]byte("max_packet_size"), []byte("2GB"), []byte("2147483647"), []byte("yes")},
+		{[]byte("tcp_user_timeout"), []byte("1500"), []byte("0"), []byte("yes")},
+	}
+	want := map[string]float64{
+		"query_timeout_seconds":       0.5,
+		"query_wait_timeout_seconds":  120,
+		"server_lifetime_seconds":     3600,
+		"autodb_idle_timeout_seconds": 3600,
+		"pkt_buf_bytes":               4096,
+		"max_packet_size_bytes":       2 << 30,
+		"tcp_user_timeout_seconds":    1.5,
+	}
+
+	values := configValues(t, columns, rows)
+	for name, v := range want {
+		if got := values[name]; math.Abs(got-v) > 1e-9 {
+			t.Errorf("%s = %v, want %v", name, got, v)
+		}
+	}
+}
+
+func TestConfigValuesInMicroseconds(t *testing.T) {
+	// The defaults of server_lifetime and query_wait_timeout tell the time_usec keys are printed in microseconds
+	columns := []string{"key", "value", "default", "changeable"}
+	rows := [][]interface{}{
+		{[]byte("query_timeout"), []byte("2500000"), []byte("0"), []byte("yes")},
+		{[]byte("server_lifetime"), []byte("1800000000"), []byte("3600000000"), []byte("yes")},
+		{[]byte("query_wait_timeout"), []byte("120000000"), []byte("120000000"), []byte("yes")},
+		{[]byte("stats_period"), []byte("60"), []byte("60"), []byte("yes")},
+		{[]byte("pkt_buf"), []byte("4096"), []byte("4096"), []byte("no")},
+	}
+	want := map[string]float64{
+		"query_timeout_seconds":      2.5,
+		"server_lifetime_seconds":    1800,
+		"query_wait_timeout_seconds": 120,
+		"stats_period_seconds":       60,
+		"pkt_buf_bytes":              4096,
+	}
+
+	values := configValues(t, columns, rows)
+	for name, v := range want {
+		if got := values[name]; math.Abs(got-v) > 1e-9 {
+			t.Errorf("%s = %v, want %v", name, got, v)
+		}
+	}
+}
+
+func TestPoolsMaxwaitWithMicroseconds(t *testing.T) {
+	metricGroup := buildMetricGroup(MetricDescriptorPools, nil)
+	columns := []string{"database", "user", "cl_waiting", "maxwait", "maxwait_us", "pool_mode"}
+	row := []interface{}{[]byte("app"), []byte("app"), int64(3), int64(2), int64(500000), []byte("transaction")}
+
+	metrics := extractRow(metricGroup, columns, row)
+	if len(metrics) != 2 {
+		t.Fatalf("got %d series, want cl_waiting and maxwait_seconds", len(metrics))
+	}
+	var pb dto.Metric
+	if err := metrics[1].Write(&pb); err != nil {
+		t.Fatal(err)
+	}
+	if metrics[1].Desc() != &metricGroup.Metrics["maxwait"].Desc || pb.GetGauge().GetValue() != 2.5 {
+		t.Errorf("got %s = %v, want pgbouncer_pools_maxwait_seconds = 2.5", metrics[1].Desc(), pb.GetGauge().GetValue())
+	}
+}
+
+func TestCollectorDatabaseInfo(t *testing.T) {
+	pgbouncer := &fakePgbouncer{}
+	columns, rows := readFixture(t, filepath.Join("testdata", "show-databases", "pgbouncer-1.21.txt"))
+	pgbouncer.set("SHOW DATABASES;", columns, rows)
+	collector := newFakeCollector(t, pgbouncer, TargetConfig{}, &Config{
+		Collectors:         []string{"databases"},
+		DatabaseInfoMetric: true,
+	})
+	series := gatherSeries(t, prometheus.Labels{"cluster": "main"}, collector)
+
+	// The gauges are keyed by name only, the descriptive labels go to the info series
+	want := map[string]float64{
+		`pgbouncer_databases_pool_size{cluster="main",name="app"}`:                                                                                       4,
+		`pgbouncer_databases_current_connections{cluster="main",name="app"}`:                                                                             5,
+		`pgbouncer_databases_pool_size{cluster="main",name="pgbouncer"}`:                                                                                 2,
+		`pgbouncer_database_info{cluster="main",database="app_db",force_user="",host="10.0.0.10",name="app",pool_mode="",port="5432"}`:                   1,
+		`pgbouncer_database_info{cluster="main",database="pgbouncer",force_user="pgbouncer",host="",name="pgbouncer",pool_mode="statement",port="6432"}`: 1,
+		`pgbouncer_up{cluster="main"}`: 1,
+	}
+	for name, v := range want {
+		m, ok := series[name]
+		if !ok {
+			t.Errorf("missing %s", name)
+			continue
+		}
+		if got := m.GetGauge().GetValue(); got != v {
+			t.Errorf("%s = %v, want %v", name, got, v)
+		}
+	}
+	for name := range series {
+		if strings.HasPrefix(name, "pgbouncer_databases_") && strings.Contains(name, "host=") {
+			t.Errorf("gauge with descriptive labels %s", name)
+		}
+	}
+}
+
+func TestCollectorDatabaseFilters(t *testing.T) {
+	pgbouncer := &fakePgbouncer{}
+	for query, path := range map[string]string{
+		"SHOW POOLS;":     filepath.Join("testdata", "show-pools", "pgbouncer-1.21.txt"),
+		"SHOW DATABASES;": filepath.Join("testdata", "show-databases", "pgbouncer-1.21.txt"),
+	} {
+		columns, rows := readFixture(t, path)
+		pgbouncer.set(query, columns, rows)
+	}
+	filters := []*LabelFilter{{Label: "database", Exclude: "pgbouncer"}, {Label: "user", Include: "alice|pgbouncer"}}
+	for _, f := range filters {
+		if err := f.compile(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	collector := newFakeCollector(t, pgbouncer, TargetConfig{}, &Config{
+		Collectors:   []string{"pools", "databases"},
+		LabelFilters: filters,
+	})
+	series := gatherSeries(t, nil, collector)
+
+	// The admin database is dropped from POOLS and by its name from DATABASES, bob is dropped from POOLS
+	for _, name := range []string{
+		`pgbouncer_pools_cl_active{database="app",pool_mode="transaction",user="alice"}`,
+		`pgbouncer_databases_pool_size{database="app_db",force_user="",host="10.0.0.10",name="app",pool_mode="",port="5432"}`,
+	} {
+		if _, ok := series[name]; !ok {
+			t.Errorf("missing %s", name)
+		}
+	}
+	for name := range series {
+		if strings.Contains(name, `"pgbouncer"`) || strings.Contains(name, `"bob"`) {
+			t.Errorf("filtered series %s", name)
+		}
+	}
+	want := map[string]float64{
+		`pgbouncer_filtered_rows_total{collector="pools"}`:     2,
+		`pgbouncer_filtered_rows_total{collector="databases"}`: 1,
+	}
+	for name, v := range want {
+		if got := series[name].GetCounter().GetValue(); got != v {
+			t.Errorf("%s = %v, want %v", name, got, v)
+		}
+	}
+}
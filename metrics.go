@@ -1,11 +1,18 @@
 package main
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
 
 type MetricDescriptor struct {
 	Prefix      string
 	Labels      []string
 	MetricProps []MetricProps
+	// DatabaseLabel is the label with the pgbouncer database name when it is not "database"
+	DatabaseLabel string
 }
 
 type MetricProps struct {
@@ -13,6 +20,61 @@ type MetricProps struct {
 	Factor float64
 	Name   string
 	Help   string
+	// Aggregate is how overflow series are merged: sum (default), max or none to drop them
+	Aggregate string
+	// Unit is the suffix of the metric name before _total, Factor converts plain numbers to it (default 1)
+	Unit string
+	// Column is the PgBouncer column of the metric, defaults to Name
+	Column string
+	// MicrosColumn holds the microsecond part of a seconds column, it is added to the value
+	MicrosColumn string
+	// SourceUnit is the unit PgBouncer prints plain numbers of a CONFIG key in, it sets Factor
+	SourceUnit string
+	// Default is the documented default of a CONFIG key in SourceUnit
+	Default float64
+	// Setting marks configuration and state every so_reuseport peer shares, its peer total is the maximum
+	Setting bool
+}
+
+const (
+	unitSeconds = "seconds"
+	unitBytes   = "bytes"
+
+	// sourceTimeUsec marks CONFIG keys PgBouncer keeps in microseconds (CF_TIME_USEC) and prints in seconds,
+	// output printing them in microseconds is recognized by its default column, see configMicroseconds
+	sourceTimeUsec = "time_usec"
+)
+
+// validate checks the naming rules: counters end in _total, units are suffixed and help is set
+func (p MetricProps) validate() error {
+	name := p.Name
+	switch {
+	case len(p.Help) == 0:
+		return fmt.Errorf("%s: empty help", p.Name)
+	case p.Type == prometheus.CounterValue && !strings.HasSuffix(name, "_total"):
+		return fmt.Errorf("%s: counter name must end in _total", p.Name)
+	case p.Type != prometheus.CounterValue && strings.HasSuffix(name, "_total"):
+		return fmt.Errorf("%s: only counter names end in _total", p.Name)
+	}
+	name = strings.TrimSuffix(name, "_total")
+	switch p.Unit {
+	case "":
+		for _, unit := range []string{unitSeconds, unitBytes} {
+			if strings.HasSuffix(name, "_"+unit) {
+				return fmt.Errorf("%s: unit %s is not declared", p.Name, unit)
+			}
+		}
+	case unitSeconds, unitBytes:
+		if !strings.HasSuffix(name, "_"+p.Unit) {
+			return fmt.Errorf("%s: name must end in the unit %s", p.Name, p.Unit)
+		}
+	default:
+		return fmt.Errorf("%s: unknown unit %q", p.Name, p.Unit)
+	}
+	if _, ok := unitFactors[p.SourceUnit]; len(p.SourceUnit) != 0 && p.SourceUnit != sourceTimeUsec && !ok {
+		return fmt.Errorf("%s: unknown source unit %q", p.Name, p.SourceUnit)
+	}
+	return nil
 }
 
 var InternalMetricUp = MetricProps{
@@ -31,12 +93,56 @@ var InternalMetricScrapeTotal = MetricProps{
 	Type: prometheus.CounterValue, Name: "scrape_total", Help: "Total number of times pgbouncer has been scraped for metrics",
 }
 
+var InternalMetricConnectionErrors = MetricProps{
+	Type: prometheus.CounterValue, Name: "connection_errors_total", Help: "Total number of failed connections to pgbouncer by reason",
+}
+
+var InternalMetricFilteredRows = MetricProps{
+	Type: prometheus.CounterValue, Name: "filtered_rows_total", Help: "Total number of rows dropped by label filters",
+}
+
+var InternalMetricSeriesDropped = MetricProps{
+	Type: prometheus.CounterValue, Name: "series_dropped_total", Help: "Total number of series over the series limits, dropped or aggregated into the other label value",
+}
+
+var InternalMetricStartTime = MetricProps{
+	Type: prometheus.GaugeValue, Name: "start_time_seconds", Help: "Start time of pgbouncer in unix epoch, read from the process or estimated at a detected restart", Unit: unitSeconds,
+}
+
+var InternalMetricRestartsDetected = MetricProps{
+	Type: prometheus.CounterValue, Name: "restarts_detected_total", Help: "Total number of pgbouncer restarts detected from the STATS counters going down",
+}
+
+var InternalMetricValueParseErrors = MetricProps{
+	Type: prometheus.CounterValue, Name: "value_parse_errors_total", Help: "Total number of column values that could not be converted to a number",
+}
+
+var InternalMetricConfigLastReloadSuccessful = MetricProps{
+	Type: prometheus.GaugeValue, Name: "config_last_reload_successful", Help: "Whether the last configuration reload attempt was successful",
+}
+
+var InternalMetricConfigLastReloadSuccessTime = MetricProps{
+	Type: prometheus.GaugeValue, Name: "config_last_reload_success_timestamp_seconds", Help: "Timestamp of the last successful configuration reload in unix epoch", Unit: unitSeconds,
+}
+
+var InternalMetricHTTPRequestDuration = MetricProps{
+	Name: "exporter_http_request_duration_seconds", Help: "Duration of the HTTP requests to the exporter by handler", Unit: unitSeconds,
+}
+
+var InternalMetricHTTPRequestsInFlight = MetricProps{
+	Type: prometheus.GaugeValue, Name: "exporter_http_requests_in_flight", Help: "Current number of HTTP requests to the exporter by handler",
+}
+
+var InternalMetricClientCertExpiry = MetricProps{
+	Type: prometheus.GaugeValue, Name: "client_cert_expiry_timestamp_seconds", Help: "Expiry timestamp of the client certificate used to connect to pgbouncer in unix epoch", Unit: unitSeconds,
+}
+
 var MetricDescriptorLists = MetricDescriptor{
 	Prefix: "lists",
 	Labels: []string{},
 	MetricProps: []MetricProps{
-		{Type: prometheus.GaugeValue, Name: "databases", Help: "Count of databases"},
-		{Type: prometheus.GaugeValue, Name: "users", Help: "Count of users"},
+		{Type: prometheus.GaugeValue, Name: "databases", Help: "Count of databases", Setting: true},
+		{Type: prometheus.GaugeValue, Name: "users", Help: "Count of users", Setting: true},
 		{Type: prometheus.GaugeValue, Name: "pools", Help: "Count of pools"},
 		{Type: prometheus.GaugeValue, Name: "free_clients", Help: "Count of free clients"},
 		{Type: prometheus.GaugeValue, Name: "used_clients", Help: "Count of used clients"},
@@ -54,20 +160,20 @@ var MetricDescriptorStats = MetricDescriptor{
 	Prefix: "stats",
 	Labels: []string{"database"},
 	MetricProps: []MetricProps{
-		{Type: prometheus.CounterValue, Name: "total_xact_count", Help: "Total number of SQL transactions pooled"},
-		{Type: prometheus.CounterValue, Name: "total_query_count", Help: "Total number of SQL queries pooled"},
-		{Type: prometheus.CounterValue, Name: "total_received", Help: "Total volume in bytes of network traffic received by pgbouncer, shown as bytes"},
-		{Type: prometheus.CounterValue, Name: "total_sent", Help: "Total volume in bytes of network traffic sent by pgbouncer, shown as bytes"},
-		{Type: prometheus.CounterValue, Name: "total_xact_time", Help: "Total number of microseconds spent by pgbouncer when connected to PostgreSQL in a transaction, either idle in transaction or executing queries"},
-		{Type: prometheus.CounterValue, Name: "total_query_time", Help: "Total number of microseconds spent by pgbouncer when actively connected to PostgreSQL, executing queries"},
-		{Type: prometheus.CounterValue, Name: "total_wait_time", Help: "Time spent by clients waiting for a server in microseconds"},
+		{Type: prometheus.CounterValue, Name: "xacts_total", Help: "Total number of SQL transactions pooled", Column: "total_xact_count"},
+		{Type: prometheus.CounterValue, Name: "queries_total", Help: "Total number of SQL queries pooled", Column: "total_query_count"},
+		{Type: prometheus.CounterValue, Name: "received_bytes_total", Help: "Total volume in bytes of network traffic received by pgbouncer, shown as bytes", Unit: unitBytes, Column: "total_received"},
+		{Type: prometheus.CounterValue, Name: "sent_bytes_total", Help: "Total volume in bytes of network traffic sent by pgbouncer, shown as bytes", Unit: unitBytes, Column: "total_sent"},
+		{Type: prometheus.CounterValue, Name: "xact_time_seconds_total", Help: "Total time spent by pgbouncer when connected to PostgreSQL in a transaction, either idle in transaction or executing queries", Factor: 1e-6, Unit: unitSeconds, Column: "total_xact_time"},
+		{Type: prometheus.CounterValue, Name: "query_time_seconds_total", Help: "Total time spent by pgbouncer when actively connected to PostgreSQL, executing queries", Factor: 1e-6, Unit: unitSeconds, Column: "total_query_time"},
+		{Type: prometheus.CounterValue, Name: "wait_time_seconds_total", Help: "Time spent by clients waiting for a server", Factor: 1e-6, Unit: unitSeconds, Column: "total_wait_time"},
 		{Type: prometheus.GaugeValue, Name: "avg_xact_count", Help: "Average transactions per second in last stat period"},
 		{Type: prometheus.GaugeValue, Name: "avg_query_count", Help: "Average queries per second in last stat period"},
 		{Type: prometheus.GaugeValue, Name: "avg_recv", Help: "Average received (from clients) bytes per second"},
 		{Type: prometheus.GaugeValue, Name: "avg_sent", Help: "Average sent (to clients) bytes per second"},
-		{Type: prometheus.GaugeValue, Name: "avg_xact_time", Help: "Average transaction duration in microseconds"},
-		{Type: prometheus.GaugeValue, Name: "avg_query_time", Help: "Average query duration in microseconds"},
-		{Type: prometheus.GaugeValue, Name: "avg_wait_time", Help: "Time spent by clients waiting for a server in microseconds (average per second)"},
+		{Type: prometheus.GaugeValue, Name: "avg_xact_time_seconds", Help: "Average transaction duration", Aggregate: aggregateMax, Factor: 1e-6, Unit: unitSeconds, Column: "avg_xact_time"},
+		{Type: prometheus.GaugeValue, Name: "avg_query_time_seconds", Help: "Average query duration", Aggregate: aggregateMax, Factor: 1e-6, Unit: unitSeconds, Column: "avg_query_time"},
+		{Type: prometheus.GaugeValue, Name: "avg_wait_time_seconds", Help: "Time spent by clients waiting for a server (average per second)", Aggregate: aggregateMax, Factor: 1e-6, Unit: unitSeconds, Column: "avg_wait_time"},
 	},
 }
 
@@ -82,21 +188,62 @@ var MetricDescriptorPools = MetricDescriptor{
 		{Type: prometheus.GaugeValue, Name: "sv_used", Help: "Server connections idle more than server_check_delay, needing server_check_query, shown as connection"},
 		{Type: prometheus.GaugeValue, Name: "sv_tested", Help: "Server connections currently running either server_reset_query or server_check_query, shown as connection"},
 		{Type: prometheus.GaugeValue, Name: "sv_login", Help: "Server connections currently in the process of logging in, shown as connection"},
-		{Type: prometheus.GaugeValue, Name: "maxwait", Help: "Age of oldest unserved client connection, shown as second"},
-		{Type: prometheus.GaugeValue, Name: "maxwait_us", Help: ""},
+		{Type: prometheus.GaugeValue, Name: "maxwait_seconds", Help: "Age of oldest unserved client connection, shown as second", Aggregate: aggregateMax, Unit: unitSeconds, Column: "maxwait", MicrosColumn: "maxwait_us"},
+	},
+}
+
+var MetricDescriptorPeers = MetricDescriptor{
+	Prefix: "peers",
+	Labels: []string{"peer_id", "host", "port"},
+	MetricProps: []MetricProps{
+		{Type: prometheus.GaugeValue, Name: "pool_size", Help: "Maximum number of connections to the peer, shown as connection", Setting: true},
+	},
+}
+
+// MetricDescriptorFds counts the SHOW FDS rows, it has no value columns
+var MetricDescriptorFds = MetricDescriptor{
+	Prefix: "fds",
+	Labels: []string{"task"},
+	MetricProps: []MetricProps{
+		{Type: prometheus.GaugeValue, Name: "open", Help: "Number of sockets of pgbouncer by task, listener, client or server"},
 	},
 }
 
 var MetricDescriptorDatabases = MetricDescriptor{
-	Prefix: "databases",
-	Labels: []string{"name", "host", "port", "database", "force_user", "pool_mode"},
+	Prefix:        "databases",
+	Labels:        []string{"name", "host", "port", "database", "force_user", "pool_mode"},
+	DatabaseLabel: "name",
 	MetricProps: []MetricProps{
-		{Type: prometheus.GaugeValue, Name: "pool_size", Help: "Maximum number of pool backend connections"},
-		{Type: prometheus.GaugeValue, Name: "reserve_pool", Help: "Maximum amount that the pool size can be exceeded temporarily"},
-		{Type: prometheus.GaugeValue, Name: "max_connections", Help: "Maximum number of client connections allowed"},
+		{Type: prometheus.GaugeValue, Name: "pool_size", Help: "Maximum number of pool backend connections", Setting: true},
+		{Type: prometheus.GaugeValue, Name: "reserve_pool", Help: "Maximum amount that the pool size can be exceeded temporarily", Setting: true},
+		{Type: prometheus.GaugeValue, Name: "max_connections", Help: "Maximum number of client connections allowed", Setting: true},
 		{Type: prometheus.GaugeValue, Name: "current_connections", Help: "Current number of client connections"},
-		{Type: prometheus.GaugeValue, Name: "paused", Help: "Boolean indicating whether a pgbouncer PAUSE is currently active for this database"},
-		{Type: prometheus.GaugeValue, Name: "disabled", Help: "Boolean indicating whether a pgbouncer DISABLE is currently active for this database"},
+		{Type: prometheus.GaugeValue, Name: "paused", Help: "Boolean indicating whether a pgbouncer PAUSE is currently active for this database", Setting: true},
+		{Type: prometheus.GaugeValue, Name: "disabled", Help: "Boolean indicating whether a pgbouncer DISABLE is currently active for this database", Setting: true},
+	},
+}
+
+// MetricDescriptorDatabasesByName keys the DATABASES gauges by name only, the other labels go to MetricDescriptorDatabaseInfo
+var MetricDescriptorDatabasesByName = MetricDescriptor{
+	Prefix:        "databases",
+	Labels:        []string{"name"},
+	DatabaseLabel: "name",
+	MetricProps: []MetricProps{
+		{Type: prometheus.GaugeValue, Name: "pool_size", Help: "Maximum number of pool backend connections", Setting: true},
+		{Type: prometheus.GaugeValue, Name: "reserve_pool", Help: "Maximum amount that the pool size can be exceeded temporarily", Setting: true},
+		{Type: prometheus.GaugeValue, Name: "max_connections", Help: "Maximum number of client connections allowed", Setting: true},
+		{Type: prometheus.GaugeValue, Name: "current_connections", Help: "Current number of client connections"},
+		{Type: prometheus.GaugeValue, Name: "paused", Help: "Boolean indicating whether a pgbouncer PAUSE is currently active for this database", Setting: true},
+		{Type: prometheus.GaugeValue, Name: "disabled", Help: "Boolean indicating whether a pgbouncer DISABLE is currently active for this database", Setting: true},
+	},
+}
+
+var MetricDescriptorDatabaseInfo = MetricDescriptor{
+	Prefix:        "database",
+	Labels:        []string{"name", "host", "port", "database", "force_user", "pool_mode"},
+	DatabaseLabel: "name",
+	MetricProps: []MetricProps{
+		{Type: prometheus.GaugeValue, Name: "info", Help: "Information about the pgbouncer database, always 1", Aggregate: aggregateMax},
 	},
 }
 
@@ -104,46 +251,182 @@ var MetricDescriptorConfig = MetricDescriptor{
 	Prefix: "config",
 	Labels: []string{},
 	MetricProps: []MetricProps{
-		{Type: prometheus.CounterValue, Name: "listen_backlog", Help: "Maximum number of backlogged listen connections before further connection attempts are dropped"},
-		{Type: prometheus.CounterValue, Name: "disable_pqexec", Help: "Boolean; 1 means pgbouncer enforce Simple Query Protocol; 0 means it allows multiple queries in a single packet"},
-		{Type: prometheus.CounterValue, Name: "pkt_buf", Help: "Internal buffer size for packets. See docs"},
+		{Type: prometheus.GaugeValue, Name: "listen_backlog", Help: "Maximum number of backlogged listen connections before further connection attempts are dropped"},
+		{Type: prometheus.GaugeValue, Name: "disable_pqexec", Help: "Boolean; 1 means pgbouncer enforce Simple Query Protocol; 0 means it allows multiple queries in a single packet"},
+		{Type: prometheus.GaugeValue, Name: "pkt_buf_bytes", Help: "Internal buffer size for packets. See docs", Unit: unitBytes, Column: "pkt_buf", SourceUnit: "B"},
 		{Type: prometheus.GaugeValue, Name: "max_client_conn", Help: "Maximum number of client connections allowed"},
 		{Type: prometheus.GaugeValue, Name: "default_pool_size", Help: "The default for how many server connections to allow per user/database pair"},
 		{Type: prometheus.GaugeValue, Name: "min_pool_size", Help: "Minimum number of backends a pool will always retain"},
 		{Type: prometheus.GaugeValue, Name: "reserve_pool_size", Help: "How many additional connections to allow to a pool once it's crossed it's maximum"},
-		{Type: prometheus.GaugeValue, Name: "reserve_pool_timeout", Help: "If a client has not been serviced in this many seconds, pgbouncer enables use of additional connections from reserve pool"},
+		{Type: prometheus.GaugeValue, Name: "reserve_pool_timeout_seconds", Help: "If a client has not been serviced in this many seconds, pgbouncer enables use of additional connections from reserve pool", Unit: unitSeconds, Column: "reserve_pool_timeout", SourceUnit: sourceTimeUsec, Default: 5},
 		{Type: prometheus.GaugeValue, Name: "max_db_connections", Help: "Server level maximum connections enforced for a given db, irregardless of pool limits"},
 		{Type: prometheus.GaugeValue, Name: "max_user_connections", Help: "Maximum number of connections a user can open irregardless of pool limits"},
-		{Type: prometheus.GaugeValue, Name: "autodb_idle_timeout", Help: "Unused pools created via '*' are reclaimed after this interval"},
+		{Type: prometheus.GaugeValue, Name: "autodb_idle_timeout_seconds", Help: "Unused pools created via '*' are reclaimed after this interval", Unit: unitSeconds, Column: "autodb_idle_timeout", SourceUnit: sourceTimeUsec, Default: 3600},
 		{Type: prometheus.GaugeValue, Name: "server_reset_query_always", Help: "Boolean indicating whether or not server_reset_query is enforced for all pooling modes, or just session"},
-		{Type: prometheus.GaugeValue, Name: "server_check_delay", Help: "How long to keep released connections available for immediate re-use, without running sanity-check queries on it. If 0 then the query is ran always"},
-		{Type: prometheus.GaugeValue, Name: "query_timeout", Help: "Maximum time that a query can run for before being cancelled"},
-		{Type: prometheus.GaugeValue, Name: "query_wait_timeout", Help: "Maximum time that a query can wait to be executed before being cancelled"},
-		{Type: prometheus.GaugeValue, Name: "client_idle_timeout", Help: "Client connections idling longer than this many seconds are closed"},
-		{Type: prometheus.GaugeValue, Name: "client_login_timeout", Help: "Maximum time in seconds for a client to either login, or be disconnected"},
-		{Type: prometheus.GaugeValue, Name: "idle_transaction_timeout", Help: "If client has been in 'idle in transaction' state longer than this amount in seconds, it will be disconnected"},
-		{Type: prometheus.GaugeValue, Name: "server_lifetime", Help: "The pooler will close an unused server connection that has been connected longer than this many seconds"},
-		{Type: prometheus.GaugeValue, Name: "server_idle_timeout", Help: "If a server connection has been idle more than this many seconds it will be dropped"},
-		{Type: prometheus.GaugeValue, Name: "server_connect_timeout", Help: "Maximum time allowed for connecting and logging into a backend server"},
-		{Type: prometheus.GaugeValue, Name: "server_login_retry", Help: "If connecting to a backend failed, this is the wait interval in seconds before retrying"},
+		{Type: prometheus.GaugeValue, Name: "server_check_delay_seconds", Help: "How long to keep released connections available for immediate re-use, without running sanity-check queries on it. If 0 then the query is ran always", Unit: unitSeconds, Column: "server_check_delay", SourceUnit: sourceTimeUsec, Default: 30},
+		{Type: prometheus.GaugeValue, Name: "query_timeout_seconds", Help: "Maximum time that a query can run for before being cancelled", Unit: unitSeconds, Column: "query_timeout", SourceUnit: sourceTimeUsec},
+		{Type: prometheus.GaugeValue, Name: "query_wait_timeout_seconds", Help: "Maximum time that a query can wait to be executed before being cancelled", Unit: unitSeconds, Column: "query_wait_timeout", SourceUnit: sourceTimeUsec, Default: 120},
+		{Type: prometheus.GaugeValue, Name: "client_idle_timeout_seconds", Help: "Client connections idling longer than this many seconds are closed", Unit: unitSeconds, Column: "client_idle_timeout", SourceUnit: sourceTimeUsec},
+		{Type: prometheus.GaugeValue, Name: "client_login_timeout_seconds", Help: "Maximum time in seconds for a client to either login, or be disconnected", Unit: unitSeconds, Column: "client_login_timeout", SourceUnit: sourceTimeUsec, Default: 60},
+		{Type: prometheus.GaugeValue, Name: "idle_transaction_timeout_seconds", Help: "If client has been in 'idle in transaction' state longer than this amount in seconds, it will be disconnected", Unit: unitSeconds, Column: "idle_transaction_timeout", SourceUnit: sourceTimeUsec},
+		{Type: prometheus.GaugeValue, Name: "server_lifetime_seconds", Help: "The pooler will close an unused server connection that has been connected longer than this many seconds", Unit: unitSeconds, Column: "server_lifetime", SourceUnit: sourceTimeUsec, Default: 3600},
+		{Type: prometheus.GaugeValue, Name: "server_idle_timeout_seconds", Help: "If a server connection has been idle more than this many seconds it will be dropped", Unit: unitSeconds, Column: "server_idle_timeout", SourceUnit: sourceTimeUsec, Default: 600},
+		{Type: prometheus.GaugeValue, Name: "server_connect_timeout_seconds", Help: "Maximum time allowed for connecting and logging into a backend server", Unit: unitSeconds, Column: "server_connect_timeout", SourceUnit: sourceTimeUsec, Default: 15},
+		{Type: prometheus.GaugeValue, Name: "server_login_retry_seconds", Help: "If connecting to a backend failed, this is the wait interval in seconds before retrying", Unit: unitSeconds, Column: "server_login_retry", SourceUnit: sourceTimeUsec, Default: 15},
 		{Type: prometheus.GaugeValue, Name: "server_round_robin", Help: "Boolean; if 1, pgbouncer uses backends in a round robin fashion.  If 0, it uses LIFO to minimize connectivity to backends"},
-		{Type: prometheus.GaugeValue, Name: "suspend_timeout", Help: "Timeout for how long pgbouncer waits for buffer flushes before killing connections during pgbouncer admin SHUTDOWN and SUSPEND invocations"},
-		{Type: prometheus.GaugeValue, Name: "dns_max_ttl", Help: "Irregardless of DNS TTL, this is the TTL that pgbouncer enforces for dns lookups it does for backends"},
-		{Type: prometheus.GaugeValue, Name: "dns_nxdomain_ttl", Help: "Irregardless of DNS TTL, this is the period enforced for negative DNS answers"},
-		{Type: prometheus.GaugeValue, Name: "dns_zone_check_period", Help: "Period to check if zone serial has changed"},
-		{Type: prometheus.GaugeValue, Name: "max_packet_size", Help: "Maximum packet size for postgresql packets that pgbouncer will relay to backends"},
+		{Type: prometheus.GaugeValue, Name: "suspend_timeout_seconds", Help: "Timeout for how long pgbouncer waits for buffer flushes before killing connections during pgbouncer admin SHUTDOWN and SUSPEND invocations", Unit: unitSeconds, Column: "suspend_timeout", SourceUnit: sourceTimeUsec, Default: 10},
+		{Type: prometheus.GaugeValue, Name: "dns_max_ttl_seconds", Help: "Irregardless of DNS TTL, this is the TTL that pgbouncer enforces for dns lookups it does for backends", Unit: unitSeconds, Column: "dns_max_ttl", SourceUnit: sourceTimeUsec, Default: 15},
+		{Type: prometheus.GaugeValue, Name: "dns_nxdomain_ttl_seconds", Help: "Irregardless of DNS TTL, this is the period enforced for negative DNS answers", Unit: unitSeconds, Column: "dns_nxdomain_ttl", SourceUnit: sourceTimeUsec, Default: 15},
+		{Type: prometheus.GaugeValue, Name: "dns_zone_check_period_seconds", Help: "Period to check if zone serial has changed", Unit: unitSeconds, Column: "dns_zone_check_period", SourceUnit: sourceTimeUsec},
+		{Type: prometheus.GaugeValue, Name: "max_packet_size_bytes", Help: "Maximum packet size for postgresql packets that pgbouncer will relay to backends", Unit: unitBytes, Column: "max_packet_size", SourceUnit: "B"},
 		{Type: prometheus.GaugeValue, Name: "sbuf_loopcnt", Help: "How many results to process for a given connection's packet results before switching to others to ensure fairness"},
-		{Type: prometheus.GaugeValue, Name: "tcp_defer_accept", Help: "Configurable for TCP_DEFER_ACCEPT"},
-		{Type: prometheus.GaugeValue, Name: "tcp_socket_buffer", Help: "Configurable for tcp socket buffering; 0 is kernel managed"},
-		{Type: prometheus.GaugeValue, Name: "tcpkeepalive", Help: "Boolean; if 1, tcp keepalive is enabled w/ OS defaults.  If 0, disabled"},
+		{Type: prometheus.GaugeValue, Name: "tcp_defer_accept_seconds", Help: "Configurable for TCP_DEFER_ACCEPT", Unit: unitSeconds, Column: "tcp_defer_accept", SourceUnit: "s"},
+		{Type: prometheus.GaugeValue, Name: "tcp_socket_buffer_bytes", Help: "Configurable for tcp socket buffering; 0 is kernel managed", Unit: unitBytes, Column: "tcp_socket_buffer", SourceUnit: "B"},
+		{Type: prometheus.GaugeValue, Name: "tcpkeepalive", Help: "Boolean; if 1, tcp keepalive is enabled w/ OS defaults.  If 0, disabled", Column: "tcp_keepalive"},
 		{Type: prometheus.GaugeValue, Name: "tcp_keepcnt", Help: "See TCP documentation for this field"},
-		{Type: prometheus.GaugeValue, Name: "tcp_keepidle", Help: "See TCP documentation for this field"},
-		{Type: prometheus.GaugeValue, Name: "tcp_keepintvl", Help: "See TCP documentation for this field"},
+		{Type: prometheus.GaugeValue, Name: "tcp_keepidle_seconds", Help: "See TCP documentation for this field", Unit: unitSeconds, Column: "tcp_keepidle", SourceUnit: "s"},
+		{Type: prometheus.GaugeValue, Name: "tcp_user_timeout_seconds", Help: "Maximum time transmitted data may remain unacknowledged before the connection is closed, 0 for the OS default", Unit: unitSeconds, Column: "tcp_user_timeout", SourceUnit: "ms"},
+		{Type: prometheus.GaugeValue, Name: "tcp_keepintvl_seconds", Help: "See TCP documentation for this field", Unit: unitSeconds, Column: "tcp_keepintvl", SourceUnit: "s"},
 		{Type: prometheus.GaugeValue, Name: "verbose", Help: "If log verbosity is increased.  Only relevant as a metric if log volume begins exceeding log consumption"},
-		{Type: prometheus.GaugeValue, Name: "stats_period", Help: "Periodicity in seconds of pgbouncer recalculating internal stats"},
+		{Type: prometheus.GaugeValue, Name: "stats_period_seconds", Help: "Periodicity in seconds of pgbouncer recalculating internal stats", Unit: unitSeconds, Column: "stats_period", SourceUnit: "s"},
 		{Type: prometheus.GaugeValue, Name: "log_connections", Help: "Whether connections are logged or not"},
 		{Type: prometheus.GaugeValue, Name: "log_disconnections", Help: "Whether connection disconnects are logged"},
 		{Type: prometheus.GaugeValue, Name: "log_pooler_errors", Help: "Whether pooler errors are logged or not"},
 		{Type: prometheus.GaugeValue, Name: "application_name_add_host", Help: "Whether pgbouncer add the client host address and port to the application name setting set on connection start or not"},
 	},
 }
+
+var MetricDescriptorStatsRates = MetricDescriptor{
+	Prefix: "stats_rate",
+	Labels: []string{"database"},
+	MetricProps: []MetricProps{
+		{Type: prometheus.GaugeValue, Name: "interval_seconds", Help: "Time between the two STATS scrapes the rates are computed from", Unit: unitSeconds, Aggregate: aggregateMax},
+		{Type: prometheus.GaugeValue, Name: "queries_per_second", Help: "SQL queries pooled per second since the previous scrape"},
+		{Type: prometheus.GaugeValue, Name: "xacts_per_second", Help: "SQL transactions pooled per second since the previous scrape"},
+		{Type: prometheus.GaugeValue, Name: "received_bytes_per_second", Help: "Bytes received by pgbouncer per second since the previous scrape"},
+		{Type: prometheus.GaugeValue, Name: "sent_bytes_per_second", Help: "Bytes sent by pgbouncer per second since the previous scrape"},
+		{Type: prometheus.GaugeValue, Name: "avg_query_time_seconds", Help: "Average query duration since the previous scrape", Unit: unitSeconds, Aggregate: aggregateMax},
+		{Type: prometheus.GaugeValue, Name: "avg_xact_time_seconds", Help: "Average transaction duration since the previous scrape", Unit: unitSeconds, Aggregate: aggregateMax},
+		{Type: prometheus.GaugeValue, Name: "avg_wait_time_seconds", Help: "Time clients waited for a server per transaction since the previous scrape", Unit: unitSeconds, Aggregate: aggregateMax},
+	},
+}
+
+var MetricDescriptorPoolSamples = MetricDescriptor{
+	Prefix: "pool_sampler",
+	Labels: []string{"database", "user", "pool_mode"},
+	MetricProps: []MetricProps{
+		{Type: prometheus.GaugeValue, Name: "samples", Help: "Number of SHOW POOLS samples taken since the last scrape"},
+		{Type: prometheus.GaugeValue, Name: "cl_waiting_min", Help: "Minimum of client connections waiting on a server connection since the last scrape"},
+		{Type: prometheus.GaugeValue, Name: "cl_waiting_max", Help: "Maximum of client connections waiting on a server connection since the last scrape"},
+		{Type: prometheus.GaugeValue, Name: "cl_waiting_mean", Help: "Mean of client connections waiting on a server connection since the last scrape"},
+		{Type: prometheus.GaugeValue, Name: "sv_active_min", Help: "Minimum of server connections linked to a client connection since the last scrape"},
+		{Type: prometheus.GaugeValue, Name: "sv_active_max", Help: "Maximum of server connections linked to a client connection since the last scrape"},
+		{Type: prometheus.GaugeValue, Name: "sv_active_mean", Help: "Mean of server connections linked to a client connection since the last scrape"},
+		{Type: prometheus.GaugeValue, Name: "maxwait_min_seconds", Help: "Minimum age of the oldest unserved client connection since the last scrape", Unit: unitSeconds, Aggregate: aggregateMax},
+		{Type: prometheus.GaugeValue, Name: "maxwait_max_seconds", Help: "Maximum age of the oldest unserved client connection since the last scrape", Unit: unitSeconds, Aggregate: aggregateMax},
+		{Type: prometheus.GaugeValue, Name: "maxwait_mean_seconds", Help: "Mean age of the oldest unserved client connection since the last scrape", Unit: unitSeconds, Aggregate: aggregateMax},
+	},
+}
+
+var MetricDescriptorProcess = MetricDescriptor{
+	Prefix: "process",
+	Labels: []string{},
+	MetricProps: []MetricProps{
+		{Type: prometheus.CounterValue, Name: "cpu_seconds_total", Help: "Total user and system CPU time spent by the pgbouncer process", Unit: unitSeconds},
+		{Type: prometheus.GaugeValue, Name: "resident_memory_bytes", Help: "Resident memory size of the pgbouncer process", Unit: unitBytes},
+		{Type: prometheus.GaugeValue, Name: "virtual_memory_bytes", Help: "Virtual memory size of the pgbouncer process", Unit: unitBytes},
+		{Type: prometheus.GaugeValue, Name: "threads", Help: "Number of threads of the pgbouncer process"},
+		{Type: prometheus.GaugeValue, Name: "open_fds", Help: "Number of open file descriptors of the pgbouncer process"},
+		{Type: prometheus.GaugeValue, Name: "max_fds", Help: "Open file descriptor limit (RLIMIT_NOFILE) of the pgbouncer process"},
+		{Type: prometheus.GaugeValue, Name: "start_time_seconds", Help: "Start time of the pgbouncer process in unix epoch", Unit: unitSeconds},
+	},
+}
+
+// MetricDescriptorClients holds the labels and filters of the SHOW CLIENTS histograms
+var MetricDescriptorClients = MetricDescriptor{
+	Prefix: "clients",
+	Labels: []string{"database", "user"},
+}
+
+// MetricClientWait is a histogram, Type is not used
+var MetricClientWait = MetricProps{
+	Name: "clients_wait_seconds", Help: "Wait time of clients waiting for a server connection, observed once per wait from the last scrape of SHOW CLIENTS that saw it, a lower bound short by up to one scrape interval", Unit: unitSeconds,
+}
+
+var MetricDescriptorBackendServers = MetricDescriptor{
+	Prefix: "backend",
+	Labels: []string{"host", "port"},
+	MetricProps: []MetricProps{
+		{Type: prometheus.GaugeValue, Name: "up", Help: "Whether the PostgreSQL server of the database could be queried"},
+		{Type: prometheus.GaugeValue, Name: "max_connections", Help: "max_connections of the PostgreSQL server", Setting: true},
+		{Type: prometheus.GaugeValue, Name: "server_connections", Help: "Client connections of all clients to the PostgreSQL server, shown as connection"},
+		{Type: prometheus.GaugeValue, Name: "server_headroom", Help: "Connections left before max_connections minus superuser_reserved_connections of the PostgreSQL server is reached, shown as connection"},
+	},
+}
+
+var MetricDescriptorBackendPools = MetricDescriptor{
+	Prefix: "backend",
+	Labels: []string{"host", "port", "database", "user"},
+	MetricProps: []MetricProps{
+		{Type: prometheus.GaugeValue, Name: "pooler_connections", Help: "Server connections of the pgbouncer pools to the PostgreSQL database as user, shown as connection"},
+		{Type: prometheus.GaugeValue, Name: "unaccounted_connections", Help: "Connections to the PostgreSQL database as user in pg_stat_activity minus the server connections of the pgbouncer pools, shown as connection"},
+	},
+}
+
+var MetricDescriptorBackendStates = MetricDescriptor{
+	Prefix: "backend",
+	Labels: []string{"host", "port", "database", "user", "state"},
+	MetricProps: []MetricProps{
+		{Type: prometheus.GaugeValue, Name: "connections", Help: "Connections from pgbouncer in pg_stat_activity of the PostgreSQL server by state, shown as connection"},
+	},
+}
+
+// MetricDescriptorLogEvents holds the labels and filters of the log event counters
+var MetricDescriptorLogEvents = MetricDescriptor{
+	Prefix: "log",
+	Labels: []string{"reason", "database", "user"},
+}
+
+var MetricLogEvents = MetricProps{
+	Type: prometheus.CounterValue, Name: "log_events_total", Help: "Total number of disconnects and errors in the pgbouncer log by reason",
+}
+
+var MetricLogLines = MetricProps{
+	Type: prometheus.CounterValue, Name: "log_lines_total", Help: "Total number of pgbouncer log lines read",
+}
+
+var MetricDescriptorDerivedPools = MetricDescriptor{
+	Prefix: "derived",
+	Labels: []string{"database", "user", "pool_mode"},
+	MetricProps: []MetricProps{
+		{Type: prometheus.GaugeValue, Name: "pool_server_utilization", Help: "Ratio of active server connections to the pool size of the database", Aggregate: aggregateNone},
+		{Type: prometheus.GaugeValue, Name: "pool_reserve_in_use", Help: "Server connections opened from the reserve pool above the pool size, shown as connection"},
+		{Type: prometheus.GaugeValue, Name: "pool_waiting_ratio", Help: "Ratio of waiting client connections to all client connections of the pool", Aggregate: aggregateNone},
+	},
+}
+
+var MetricDescriptorDerivedDatabases = MetricDescriptor{
+	Prefix: "derived",
+	Labels: []string{"database"},
+	MetricProps: []MetricProps{
+		{Type: prometheus.GaugeValue, Name: "database_server_headroom", Help: "Server connections left before max_db_connections of the database is reached, shown as connection"},
+	},
+}
+
+var MetricDescriptorDerivedClients = MetricDescriptor{
+	Prefix: "derived",
+	Labels: []string{},
+	MetricProps: []MetricProps{
+		{Type: prometheus.GaugeValue, Name: "client_headroom", Help: "Client connections left before max_client_conn is reached, shown as connection"},
+	},
+}
+
+var MetricDescriptorDerivedFds = MetricDescriptor{
+	Prefix: "derived",
+	Labels: []string{},
+	MetricProps: []MetricProps{
+		{Type: prometheus.GaugeValue, Name: "fd_required", Help: "File descriptors pgbouncer needs with max_client_conn clients and full pools, pool_size plus reserve_pool per pool, and its listeners"},
+		{Type: prometheus.GaugeValue, Name: "fd_headroom", Help: "File descriptors left before RLIMIT_NOFILE of the pgbouncer process is reached by the sockets of SHOW FDS"},
+		{Type: prometheus.GaugeValue, Name: "fd_limit_headroom", Help: "RLIMIT_NOFILE of the pgbouncer process minus the required file descriptors, negative if accept() may fail under full load"},
+	},
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var metricDescriptors = map[string]MetricDescriptor{
+	"MetricDescriptorLists":            MetricDescriptorLists,
+	"MetricDescriptorStats":            MetricDescriptorStats,
+	"MetricDescriptorPools":            MetricDescriptorPools,
+	"MetricDescriptorPeers":            MetricDescriptorPeers,
+	"MetricDescriptorFds":              MetricDescriptorFds,
+	"MetricDescriptorDatabases":        MetricDescriptorDatabases,
+	"MetricDescriptorDatabasesByName":  MetricDescriptorDatabasesByName,
+	"MetricDescriptorDatabaseInfo":     MetricDescriptorDatabaseInfo,
+	"MetricDescriptorConfig":           MetricDescriptorConfig,
+	"MetricDescriptorStatsRates":       MetricDescriptorStatsRates,
+	"MetricDescriptorPoolSamples":      MetricDescriptorPoolSamples,
+	"MetricDescriptorProcess":          MetricDescriptorProcess,
+	"MetricDescriptorClients":          MetricDescriptorClients,
+	"MetricDescriptorBackendServers":   MetricDescriptorBackendServers,
+	"MetricDescriptorBackendPools":     MetricDescriptorBackendPools,
+	"MetricDescriptorBackendStates":    MetricDescriptorBackendStates,
+	"MetricDescriptorLogEvents":        MetricDescriptorLogEvents,
+	"MetricDescriptorDerivedPools":     MetricDescriptorDerivedPools,
+	"MetricDescriptorDerivedDatabases": MetricDescriptorDerivedDatabases,
+	"MetricDescriptorDerivedClients":   MetricDescriptorDerivedClients,
+	"MetricDescriptorDerivedFds":       MetricDescriptorDerivedFds,
+}
+
+var metricProps = map[string]MetricProps{
+	"InternalMetricUp":                          InternalMetricUp,
+	"InternalMetricErrors":                      InternalMetricErrors,
+	"InternalMetricScrapeLastTime":              InternalMetricScrapeLastTime,
+	"InternalMetricScrapeTotal":                 InternalMetricScrapeTotal,
+	"InternalMetricConnectionErrors":            InternalMetricConnectionErrors,
+	"InternalMetricFilteredRows":                InternalMetricFilteredRows,
+	"InternalMetricSeriesDropped":               InternalMetricSeriesDropped,
+	"InternalMetricStartTime":                   InternalMetricStartTime,
+	"InternalMetricRestartsDetected":            InternalMetricRestartsDetected,
+	"InternalMetricValueParseErrors":            InternalMetricValueParseErrors,
+	"InternalMetricConfigLastReloadSuccessful":  InternalMetricConfigLastReloadSuccessful,
+	"InternalMetricConfigLastReloadSuccessTime": InternalMetricConfigLastReloadSuccessTime,
+	"InternalMetricHTTPRequestDuration":         InternalMetricHTTPRequestDuration,
+	"InternalMetricHTTPRequestsInFlight":        InternalMetricHTTPRequestsInFlight,
+	"InternalMetricClientCertExpiry":            InternalMetricClientCertExpiry,
+	"MetricClientWait":                          MetricClientWait,
+	"MetricLogEvents":                           MetricLogEvents,
+	"MetricLogLines":                            MetricLogLines,
+}
+
+func TestMetricDescriptorsValidate(t *testing.T) {
+	for name, descriptor := range metricDescriptors {
+		for _, props := range descriptor.MetricProps {
+			if err := props.validate(); err != nil {
+				t.Errorf("%s: %v", name, err)
+			}
+		}
+	}
+}
+
+func TestMetricPropsValidate(t *testing.T) {
+	for name, props := range metricProps {
+		if err := props.validate(); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+// TestMetricTablesComplete fails when a descriptor is added to metrics.go without adding it to the tables above
+func TestMetricTablesComplete(t *testing.T) {
+	f, err := parser.ParseFile(token.NewFileSet(), "metrics.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, decl := range f.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.VAR {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			for _, ident := range spec.(*ast.ValueSpec).Names {
+				name := ident.Name
+				_, isDescriptor := metricDescriptors[name]
+				_, isProps := metricProps[name]
+				if (strings.HasPrefix(name, "Metric") || strings.HasPrefix(name, "InternalMetric")) && !isDescriptor && !isProps {
+					t.Errorf("%s is not validated, add it to the tables of metrics_test.go", name)
+				}
+			}
+		}
+	}
+}
+
+func TestMetricPropsValidateRejects(t *testing.T) {
+	tests := []struct {
+		name  string
+		props MetricProps
+	}{
+		{"empty help", MetricProps{Type: prometheus.GaugeValue, Name: "pool_size"}},
+		{"counter without _total", MetricProps{Type: prometheus.CounterValue, Name: "xacts", Help: "h"}},
+		{"gauge with _total", MetricProps{Type: prometheus.GaugeValue, Name: "xacts_total", Help: "h"}},
+		{"undeclared seconds", MetricProps{Type: prometheus.GaugeValue, Name: "maxwait_seconds", Help: "h"}},
+		{"undeclared bytes", MetricProps{Type: prometheus.CounterValue, Name: "sent_bytes_total", Help: "h"}},
+		{"unit not in name", MetricProps{Type: prometheus.GaugeValue, Name: "maxwait", Help: "h", Unit: unitSeconds}},
+		{"unknown unit", MetricProps{Type: prometheus.GaugeValue, Name: "maxwait_ms", Help: "h", Unit: "ms"}},
+	}
+	for _, tt := range tests {
+		if err := tt.props.validate(); err == nil {
+			t.Errorf("%s: %s passed validation", tt.name, tt.props.Name)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// createdMetric sets the created timestamp of a counter
+type createdMetric struct {
+	prometheus.Metric
+	created *timestamppb.Timestamp
+}
+
+func (m createdMetric) Write(pb *dto.Metric) error {
+	if err := m.Metric.Write(pb); err != nil {
+		return err
+	}
+	if pb.Counter != nil {
+		pb.Counter.CreatedTimestamp = m.created
+	}
+	return nil
+}
+
+// withCreatedTimestamp attaches the created timestamp to the counters, a zero time leaves them unchanged
+func withCreatedTimestamp(metrics []prometheus.Metric, created time.Time) []prometheus.Metric {
+	if created.IsZero() {
+		return metrics
+	}
+	ts := timestamppb.New(created)
+	result := make([]prometheus.Metric, len(metrics))
+	for i, m := range metrics {
+		result[i] = createdMetric{Metric: m, created: ts}
+	}
+	return result
+}
+
+// metricUnit returns the unit of a metric family named after a unit, empty for the others
+func metricUnit(name string) string {
+	name = strings.TrimSuffix(name, "_total")
+	for _, unit := range []string{unitSeconds, unitBytes} {
+		if strings.HasSuffix(name, "_"+unit) {
+			return unit
+		}
+	}
+	return ""
+}
+
+// addUnits adds a UNIT line after the TYPE line of the metric families named after a unit
+func addUnits(body []byte) []byte {
+	var out bytes.Buffer
+	out.Grow(len(body))
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(body)+1)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		out.Write(line)
+		out.WriteByte('\n')
+		if fields := strings.Fields(string(line)); len(fields) == 4 && fields[0] == "#" && fields[1] == "TYPE" {
+			if unit := metricUnit(fields[2]); unit != "" {
+				out.WriteString("# UNIT " + fields[2] + " " + unit + "\n")
+			}
+		}
+	}
+	return out.Bytes()
+}
+
+// bufferedResponse keeps a response to rewrite it before it is sent
+type bufferedResponse struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (r *bufferedResponse) Header() http.Header         { return r.header }
+func (r *bufferedResponse) Write(b []byte) (int, error) { return r.body.Write(b) }
+func (r *bufferedResponse) WriteHeader(code int)        { r.code = code }
+
+// withUnits adds the UNIT lines to the OpenMetrics responses of handler, which promhttp does not write.
+// The response is compressed here after the UNIT lines are added, the other formats are passed through
+func withUnits(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if expfmt.NegotiateIncludingOpenMetrics(r.Header).FormatType() != expfmt.TypeOpenMetrics {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		plain := r.Clone(r.Context())
+		plain.Header.Del("Accept-Encoding")
+		resp := &bufferedResponse{header: w.Header(), code: http.StatusOK}
+		handler.ServeHTTP(resp, plain)
+
+		body := resp.body.Bytes()
+		if resp.code == http.StatusOK && strings.HasPrefix(resp.header.Get("Content-Type"), expfmt.OpenMetricsType) {
+			body = addUnits(body)
+			if acceptsGzip(r) {
+				var gz bytes.Buffer
+				zw := gzip.NewWriter(&gz)
+				if _, err := zw.Write(body); err == nil && zw.Close() == nil {
+					body = gz.Bytes()
+					w.Header().Set("Content-Encoding", "gzip")
+				}
+			}
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(resp.code)
+		if _, err := w.Write(body); err != nil {
+			logger.Error("Unable to write response", "err", err)
+		}
+	})
+}
+
+// acceptsGzip tells whether the scraper accepts a gzip response
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		encoding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.TrimSpace(encoding) != "gzip" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// metricsHandler serves the metrics of gatherer with promhttp, in the OpenMetrics format with UNIT and _created
+// lines when the scraper accepts it. The scrapes and errors are counted in the promhttp_metric_handler metrics
+// of reg, maxRequests limits the concurrent scrapes, 0 for unlimited
+func metricsHandler(gatherer prometheus.Gatherer, reg prometheus.Registerer, maxRequests int) http.Handler {
+	return promhttp.InstrumentMetricHandler(reg, withUnits(promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{
+		ErrorLog:                            slog.NewLogLogger(logger.Handler(), slog.LevelError),
+		Registry:                            reg,
+		MaxRequestsInFlight:                 maxRequests,
+		EnableOpenMetrics:                   true,
+		EnableOpenMetricsTextCreatedSamples: true,
+	})))
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// createdCollector exports a seconds counter with a created timestamp and a gauge without a unit
+type createdCollector struct {
+	counter *prometheus.Desc
+	gauge   *prometheus.Desc
+	created time.Time
+}
+
+func (c createdCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.counter
+	ch <- c.gauge
+}
+
+func (c createdCollector) Collect(ch chan<- prometheus.Metric) {
+	metrics := withCreatedTimestamp([]prometheus.Metric{
+		prometheus.MustNewConstMetric(c.counter, prometheus.CounterValue, 1.5),
+		prometheus.MustNewConstMetric(c.gauge, prometheus.GaugeValue, 3),
+	}, c.created)
+	for _, m := range metrics {
+		ch <- m
+	}
+}
+
+func TestMetricsHandlerOpenMetrics(t *testing.T) {
+	r := prometheus.NewRegistry()
+	r.MustRegister(createdCollector{
+		counter: prometheus.NewDesc("pgbouncer_stats_wait_time_seconds_total", "Wait time.", nil, nil),
+		gauge:   prometheus.NewDesc("pgbouncer_pools_cl_active", "Active clients.", nil, nil),
+		created: time.Unix(1700000000, 0),
+	})
+	server := httptest.NewServer(metricsHandler(r, prometheus.NewRegistry(), 0))
+	defer server.Close()
+
+	tests := []struct {
+		accept   string
+		encoding string
+		want     []string
+		notWant  []string
+	}{
+		{
+			accept:   "application/openmetrics-text;version=1.0.0",
+			encoding: "gzip",
+			want: []string{
+				"# TYPE pgbouncer_stats_wait_time_seconds counter\n# UNIT pgbouncer_stats_wait_time_seconds seconds\n",
+				"pgbouncer_stats_wait_time_seconds_created 1.7e+09\n",
+				"# EOF\n",
+			},
+			notWant: []string{"# UNIT pgbouncer_pools_cl_active"},
+		},
+		{
+			accept: "application/openmetrics-text;version=1.0.0",
+			want:   []string{"# UNIT pgbouncer_stats_wait_time_seconds seconds\n"},
+		},
+		{
+			accept:   "text/plain",
+			encoding: "gzip",
+			want:     []string{"pgbouncer_stats_wait_time_seconds_total 1.5\n"},
+			notWant:  []string{"# UNIT", "_created"},
+		},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept", tt.accept)
+		req.Header.Set("Accept-Encoding", tt.encoding)
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body io.Reader = resp.Body
+		if got := resp.Header.Get("Content-Encoding"); got != tt.encoding {
+			t.Errorf("%s %q: got Content-Encoding %q, want %q", tt.accept, tt.encoding, got, tt.encoding)
+		} else if got == "gzip" {
+			if body, err = gzip.NewReader(resp.Body); err != nil {
+				t.Fatal(err)
+			}
+		}
+		b, err := io.ReadAll(body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(string(b), want) {
+				t.Errorf("%s %q: missing %q in\n%s", tt.accept, tt.encoding, want, b)
+			}
+		}
+		for _, notWant := range tt.notWant {
+			if strings.Contains(string(b), notWant) {
+				t.Errorf("%s %q: unexpected %q in\n%s", tt.accept, tt.encoding, notWant, b)
+			}
+		}
+	}
+}
+
+// scrapeOpenMetrics scrapes the collector through the metrics handler in the OpenMetrics format
+func scrapeOpenMetrics(t *testing.T, collector prometheus.Collector) string {
+	t.Helper()
+	r := prometheus.NewRegistry()
+	r.MustRegister(collector)
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text;version=1.0.0")
+	rec := httptest.NewRecorder()
+	metricsHandler(r, prometheus.NewRegistry(), 0).ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body)
+	}
+	return rec.Body.String()
+}
+
+func TestStatsCreatedOnlyWithKnownStartTime(t *testing.T) {
+	pgbouncer := &fakePgbouncer{}
+	stats := func(queries int64) {
+		pgbouncer.set("SHOW STATS;", []string{"database", "total_query_count"}, [][]interface{}{{[]byte("app"), queries}})
+	}
+	collector := newFakeCollector(t, pgbouncer, TargetConfig{}, &Config{Collectors: []string{"stats"}})
+
+	// A first scrape of counters that are already large tells nothing about the start of PgBouncer
+	stats(1000)
+	body := scrapeOpenMetrics(t, collector)
+	if !strings.Contains(body, `pgbouncer_stats_queries_total{database="app"} 1000`) {
+		t.Fatalf("missing the STATS counter in\n%s", body)
+	}
+	if strings.Contains(body, "pgbouncer_stats_queries_created") || strings.Contains(body, "pgbouncer_start_time_seconds") {
+		t.Errorf("first scrape exported a start time or created timestamp\n%s", body)
+	}
+
+	// The counters going down tell the restart
+	stats(10)
+	body = scrapeOpenMetrics(t, collector)
+	if !strings.Contains(body, `pgbouncer_stats_queries_created{database="app"}`) ||
+		!strings.Contains(body, `pgbouncer_start_time_seconds{source="restart"}`) {
+		t.Errorf("restart exported no start time or created timestamp\n%s", body)
+	}
+}
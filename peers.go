@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	peerLabel      = "peer_id"
+	peerTotalLabel = "total"
+)
+
+// PeerConfig is the admin console of one PgBouncer process of a so_reuseport group,
+// the other settings are taken from the target
+type PeerConfig struct {
+	PeerID    int    `yaml:"peer_id"`
+	DSN       string `yaml:"dsn"`
+	DSNFile   string `yaml:"dsn_file"`
+	SocketDir string `yaml:"socket_dir"`
+	Port      int    `yaml:"port"`
+	PidFile   string `yaml:"pidfile"`
+	LogFile   string `yaml:"log_file"`
+	// SyslogListen receives the syslog of the peer, forward is taken from the target
+	SyslogListen string `yaml:"syslog_listen"`
+}
+
+// expand returns the target, or a target per peer of a target group
+func (t TargetConfig) expand() []TargetConfig {
+	if len(t.Peers) == 0 {
+		return []TargetConfig{t}
+	}
+	targets := make([]TargetConfig, 0, len(t.Peers))
+	for _, p := range t.Peers {
+		peer := t
+		peer.Peers = nil
+		peer.DSN, peer.DSNFile, peer.SocketDir = p.DSN, p.DSNFile, p.SocketDir
+		if p.Port != 0 {
+			peer.Port = p.Port
+		}
+		if len(p.PidFile) != 0 {
+			peer.Process = ProcessConfig{PidFile: p.PidFile}
+		}
+		peer.Log.File = p.LogFile
+		peer.Syslog.Listen = p.SyslogListen
+		peer.peerID = strconv.Itoa(p.PeerID)
+		targets = append(targets, peer)
+	}
+	return targets
+}
+
+func (t *TargetConfig) validatePeers() error {
+	if len(t.DSN) != 0 || len(t.DSNFile) != 0 || len(t.SocketDir) != 0 {
+		return fmt.Errorf("target %q: dsn, dsn_file and socket_dir are set per peer", t.Name)
+	}
+	if len(t.Log.File) != 0 {
+		return fmt.Errorf("target %q: the log file is set per peer with log_file", t.Name)
+	}
+	if len(t.Syslog.Listen) != 0 {
+		return fmt.Errorf("target %q: the syslog listen address is set per peer with syslog_listen", t.Name)
+	}
+	if len(t.Name) == 0 {
+		return fmt.Errorf("target with peers: name is required")
+	}
+	ids := make(map[int]bool)
+	for _, p := range t.Peers {
+		sources := 0
+		for _, source := range []string{p.DSN, p.DSNFile, p.SocketDir} {
+			if len(source) != 0 {
+				sources++
+			}
+		}
+		if sources != 1 {
+			return fmt.Errorf("target %q: peer %d: exactly one of dsn, dsn_file or socket_dir is required", t.Name, p.PeerID)
+		}
+		if ids[p.PeerID] {
+			return fmt.Errorf("target %q: duplicate peer_id %d", t.Name, p.PeerID)
+		}
+		ids[p.PeerID] = true
+	}
+	return nil
+}
+
+// metricAggregates maps the names of the PgBouncer metrics with peer totals to their aggregation,
+// settings of CONFIG and PEERS and the backend servers are the same for every peer and have no totals,
+// the settings and states of LISTS and DATABASES take the maximum instead of adding up the peers
+func metricAggregates() map[string]string {
+	descriptors := []MetricDescriptor{
+		MetricDescriptorDatabasesByName, MetricDescriptorStatsRates, MetricDescriptorPoolSamples,
+		MetricDescriptorDerivedPools, MetricDescriptorDerivedDatabases, MetricDescriptorDerivedClients, MetricDescriptorDerivedFds,
+	}
+	for _, def := range ScrapeDefinitions {
+		if def.Descriptor.Prefix != MetricDescriptorConfig.Prefix && def.Descriptor.Prefix != MetricDescriptorPeers.Prefix {
+			descriptors = append(descriptors, def.Descriptor)
+		}
+	}
+	aggregates := make(map[string]string)
+	for _, descriptor := range descriptors {
+		for _, v := range descriptor.MetricProps {
+			aggregate := v.Aggregate
+			if v.Setting && aggregate != aggregateNone {
+				aggregate = aggregateMax
+			}
+			aggregates[fmt.Sprintf("%s_%s_%s", namespace, descriptor.Prefix, v.Name)] = aggregate
+		}
+	}
+	return aggregates
+}
+
+// addPeerTotals adds to the PgBouncer metrics of the target groups a series with peer_id="total"
+// per label set, aggregated over the peers like the series limit overflow
+func addPeerTotals(mfs []*dto.MetricFamily, groups map[string]bool, aggregates map[string]string) {
+	for _, mf := range mfs {
+		aggregate, ok := aggregates[mf.GetName()]
+		if !ok || aggregate == aggregateNone {
+			continue
+		}
+
+		var order []string
+		totals := make(map[string]*dto.Metric)
+		for _, m := range mf.Metric {
+			var labels []*dto.LabelPair
+			isPeer, inGroup := false, false
+			var key []string
+			for _, l := range m.Label {
+				switch l.GetName() {
+				case peerLabel:
+					isPeer = true
+					value := peerTotalLabel
+					labels = append(labels, &dto.LabelPair{Name: l.Name, Value: &value})
+					continue
+				case "target":
+					inGroup = groups[l.GetValue()]
+				}
+				labels = append(labels, l)
+				key = append(key, l.GetName()+"="+l.GetValue())
+			}
+			value := metricValue(m)
+			if !isPeer || !inGroup || math.IsNaN(value) {
+				continue
+			}
+
+			k := strings.Join(key, "\xff")
+			total, ok := totals[k]
+			if !ok {
+				total = &dto.Metric{Label: labels}
+				switch {
+				case m.Counter != nil:
+					total.Counter = &dto.Counter{Value: &value}
+				case m.Gauge != nil:
+					total.Gauge = &dto.Gauge{Value: &value}
+				default:
+					total.Untyped = &dto.Untyped{Value: &value}
+				}
+				totals[k] = total
+				order = append(order, k)
+				continue
+			}
+			sum := mergeValue(aggregate, metricValue(total), value)
+			switch {
+			case total.Counter != nil:
+				total.Counter.Value = &sum
+			case total.Gauge != nil:
+				total.Gauge.Value = &sum
+			default:
+				total.Untyped.Value = &sum
+			}
+		}
+		for _, k := range order {
+			mf.Metric = append(mf.Metric, totals[k])
+		}
+		sort.Sort(metricSorter(mf.Metric))
+	}
+}
+
+// metricSorter orders the series of a family by label values like the registry
+type metricSorter []*dto.Metric
+
+func (s metricSorter) Len() int      { return len(s) }
+func (s metricSorter) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s metricSorter) Less(i, j int) bool {
+	for n, l := range s[i].Label {
+		if n >= len(s[j].Label) {
+			return false
+		}
+		if vi, vj := l.GetValue(), s[j].Label[n].GetValue(); vi != vj {
+			return vi < vj
+		}
+	}
+	return len(s[i].Label) < len(s[j].Label)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+// peerFamily returns a gauge family with a series per target and peer_id value
+func peerFamily(name string, values map[[2]string]float64) *dto.MetricFamily {
+	mf := &dto.MetricFamily{Name: proto.String(name), Type: dto.MetricType_GAUGE.Enum()}
+	for labels, v := range values {
+		mf.Metric = append(mf.Metric, &dto.Metric{
+			Label: []*dto.LabelPair{
+				{Name: proto.String(peerLabel), Value: proto.String(labels[1])},
+				{Name: proto.String("target"), Value: proto.String(labels[0])},
+			},
+			Gauge: &dto.Gauge{Value: proto.Float64(v)},
+		})
+	}
+	return mf
+}
+
+func TestAddPeerTotals(t *testing.T) {
+	defer func(ns string) { namespace = ns }(namespace)
+	namespace = "pgbouncer"
+
+	peers := func(a, b float64) map[[2]string]float64 {
+		return map[[2]string]float64{{"bouncer", "1"}: a, {"bouncer", "2"}: b, {"other", "1"}: 100}
+	}
+	tests := []struct {
+		name  string
+		peers map[[2]string]float64
+		want  float64
+		total bool
+	}{
+		// Connections and traffic add up over the peers
+		{name: "pgbouncer_lists_used_clients", peers: peers(4, 6), want: 10, total: true},
+		{name: "pgbouncer_pools_cl_active", peers: peers(4, 6), want: 10, total: true},
+		{name: "pgbouncer_databases_current_connections", peers: peers(2, 3), want: 5, total: true},
+		// Settings and states every peer shares take the maximum
+		{name: "pgbouncer_lists_databases", peers: peers(3, 3), want: 3, total: true},
+		{name: "pgbouncer_databases_pool_size", peers: peers(20, 20), want: 20, total: true},
+		{name: "pgbouncer_databases_paused", peers: peers(0, 1), want: 1, total: true},
+		{name: "pgbouncer_databases_disabled", peers: peers(0, 0), want: 0, total: true},
+		// Waits take the maximum, ratios and settings of CONFIG have no total
+		{name: "pgbouncer_pools_maxwait_seconds", peers: peers(2, 7), want: 7, total: true},
+		{name: "pgbouncer_derived_pool_server_utilization", peers: peers(0.5, 0.2)},
+		{name: "pgbouncer_config_max_client_conn", peers: peers(100, 100)},
+	}
+
+	for _, tt := range tests {
+		mf := peerFamily(tt.name, tt.peers)
+		addPeerTotals([]*dto.MetricFamily{mf}, map[string]bool{"bouncer": true}, metricAggregates())
+
+		var totals []float64
+		for _, m := range mf.Metric {
+			if m.Label[0].GetValue() == peerTotalLabel {
+				if target := m.Label[1].GetValue(); target != "bouncer" {
+					t.Errorf("%s: total of target %q outside the group", tt.name, target)
+				}
+				totals = append(totals, m.GetGauge().GetValue())
+			}
+		}
+		switch {
+		case !tt.total && len(totals) != 0:
+			t.Errorf("%s: got totals %v, want none", tt.name, totals)
+		case tt.total && (len(totals) != 1 || totals[0] != tt.want):
+			t.Errorf("%s: got totals %v, want %v", tt.name, totals, tt.want)
+		}
+	}
+}
@@ -4,33 +4,74 @@ import (
 	"database/sql"
 	"flag"
 	"fmt"
-	"github.com/lib/pq"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"log"
-	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 )
 
 var (
-	metricsPort    string
-	dataSourceName string
-	namespace      string
+	metricsPort            string
+	webMaxRequests         int
+	dataSourceName         string
+	dataSourceNameFile     string
+	socketDir              string
+	socketPort             int
+	dataSourceUser         string
+	dataSourcePasswordFile string
+	sslMode                string
+	sslRootCert            string
+	sslCert                string
+	sslKey                 string
+	sslServerName          string
+	namespace              string
+	configFile             string
+	includeDatabases       string
+	excludeDatabases       string
+	excludeUsers           string
+	maxSeries              int
+	maxSeriesPerCollector  int
+	seriesOverflow         string
+	constLabels            = constLabelsFlag{}
+	databaseInfoMetric     bool
+	clientWaitHistogram    bool
+	processPidFile         string
+	processName            string
+	logFile                string
+	logPollInterval        time.Duration
+	logMaxSeries           int
+	syslogListen           string
+	syslogForward          string
+	backendUser            string
+	backendPasswordFile    string
+	backendSSLMode         string
+	backendClientAddrs     listFlag
+	statsRatesEnabled      bool
+	samplerInterval        time.Duration
+	samplerMaxPools        int
+	onParseError           string
+	parseErrorValue        float64
+	logLevel               string
+	logFormat              string
+	logRepeatInterval      time.Duration
 )
 
 const (
 	metricsHost = "0.0.0.0"
 	metricsPath = "/metrics"
 	healthzPath = "/healthz"
+	reloadPath  = "/-/reload"
 	indexHTML   = `
 	<html>
 		<head><title>PgBouncer Metrics Exporter</title></head>
 		<body>
 			<h1>PgBouncer Metrics Exporter</h1>
 			<ul>
-				<li><a href='` + metricsPath + `'>metrics</a></li>
+				<li><a href='%s'>metrics</a></li>
 				<li><a href='` + healthzPath + `'>healthz</a></li>
 			</ul>
 		</body>
@@ -41,67 +82,120 @@ func ParseEnv() {
 	if dsn := os.Getenv("DATA_SOURCE_NAME"); len(dsn) != 0 {
 		dataSourceName = dsn
 	}
+	if file := os.Getenv("DATA_SOURCE_NAME_FILE"); len(file) != 0 {
+		dataSourceNameFile = file
+	}
+	if user := os.Getenv("DATA_SOURCE_USER"); len(user) != 0 {
+		dataSourceUser = user
+	}
+	if file := os.Getenv("DATA_SOURCE_PASS_FILE"); len(file) != 0 {
+		dataSourcePasswordFile = file
+	}
 	if port := os.Getenv("EXPORTER_WEB_LISTEN_PORT"); len(port) != 0 {
 		metricsPort = port
 	}
 	if ns := os.Getenv("EXPORTER_NAMESPACE"); len(ns) != 0 {
 		namespace = ns
 	}
+	if file := os.Getenv("EXPORTER_CONFIG_FILE"); len(file) != 0 {
+		configFile = file
+	}
 }
 
 func main() {
 	flag.StringVar(&metricsPort, "p", "9127", "Port to listen on for web interface and telemetry")
+	flag.IntVar(&webMaxRequests, "web.max-requests", 40, "Maximum number of concurrent scrape requests, 0 for unlimited")
 	flag.StringVar(&dataSourceName, "d", "postgres://pgbouncer:@localhost:6432/pgbouncer?sslmode=disable", "PgBouncer connection url")
+	flag.StringVar(&dataSourceNameFile, "dsn-file", "", "File with PgBouncer connection url, read on every reconnect")
+	flag.StringVar(&socketDir, "socket-dir", "", "PgBouncer unix socket directory, used instead of the connection url")
+	flag.IntVar(&socketPort, "socket-port", defaultSocketPort, "PgBouncer port of the unix socket")
+	flag.StringVar(&dataSourceUser, "user", "", "PgBouncer user, overrides the user of the connection url")
+	flag.StringVar(&dataSourcePasswordFile, "password-file", "", "File with PgBouncer password, read on every reconnect")
+	flag.StringVar(&sslMode, "sslmode", "", "SSL mode of PgBouncer connection (disable, require, verify-ca, verify-full)")
+	flag.StringVar(&sslRootCert, "sslrootcert", "", "Root certificate to verify PgBouncer server certificate")
+	flag.StringVar(&sslCert, "sslcert", "", "Client certificate to connect to PgBouncer")
+	flag.StringVar(&sslKey, "sslkey", "", "Client certificate key to connect to PgBouncer")
+	flag.StringVar(&sslServerName, "ssl-server-name", "", "TLS server name to send and verify instead of the PgBouncer host")
 	flag.StringVar(&namespace, "ns", "pgbouncer", "Namespace for exporter")
+	flag.StringVar(&configFile, "config.file", "", "Path to YAML config file")
+	flag.StringVar(&includeDatabases, "include-databases", "", "Regex of databases to export, others are dropped")
+	flag.StringVar(&excludeDatabases, "exclude-databases", "", "Regex of databases to drop, e.g. pgbouncer")
+	flag.StringVar(&excludeUsers, "exclude-users", "", "Regex of users to drop")
+	flag.Var(constLabels, "const-label", "Constant label key=value added to all series, can be repeated")
+	flag.BoolVar(&databaseInfoMetric, "database-info-metric", false, "Key DATABASES gauges by name and export the other labels in the database_info metric")
+	flag.DurationVar(&samplerInterval, "sampler.interval", 0, "Interval to sample SHOW POOLS between scrapes, at least 100ms, 0 to disable")
+	flag.IntVar(&samplerMaxPools, "sampler.max-pools", 500, "Maximum number of pools kept by the sampler")
+	flag.StringVar(&processPidFile, "process.pidfile", "", "PgBouncer pidfile to export process metrics from /proc")
+	flag.StringVar(&processName, "process.name", "", "PgBouncer process name to export process metrics from /proc, if there is no pidfile")
+	flag.StringVar(&logFile, "pgbouncer-log.file", "", "PgBouncer log file to follow for pgbouncer_log_events_total")
+	flag.DurationVar(&logPollInterval, "pgbouncer-log.poll-interval", defaultLogPollInterval, "Interval to check the PgBouncer log file for new lines")
+	flag.IntVar(&logMaxSeries, "pgbouncer-log.max-series", defaultLogMaxSeries, "Maximum number of database and user pairs of the log events, others are counted as other")
+	flag.StringVar(&syslogListen, "syslog.listen", "", "Receive the PgBouncer syslog on udp://host:port or unixgram:///path for pgbouncer_log_events_total")
+	flag.StringVar(&syslogForward, "syslog.forward", "", "Forward the received syslog messages to udp://host:port or unixgram:///path")
+	flag.StringVar(&backendUser, "backend.user", "", "PostgreSQL user to cross-check pg_stat_activity of the servers in SHOW DATABASES")
+	flag.StringVar(&backendPasswordFile, "backend.password-file", "", "File with the password of the PostgreSQL user, read on every reconnect")
+	flag.StringVar(&backendSSLMode, "backend.sslmode", "", "SSL mode of the PostgreSQL connections")
+	flag.Var(&backendClientAddrs, "backend.client-addr", "Address of PgBouncer seen by the PostgreSQL servers, local for unix sockets, can be repeated")
+	flag.BoolVar(&statsRatesEnabled, "stats-rates", false, "Export per-second rates of the STATS counters between scrapes")
+	flag.BoolVar(&clientWaitHistogram, "client-wait-histogram", false, "Sample the wait time of waiting clients from SHOW CLIENTS into a histogram")
+	flag.StringVar(&onParseError, "values.on-parse-error", parseErrorSkip, "What to export for values that are not numbers (skip, default)")
+	flag.Float64Var(&parseErrorValue, "values.default", 0, "Value exported for values that are not numbers with -values.on-parse-error=default")
+	flag.IntVar(&maxSeries, "limits.max-series", 0, "Maximum number of series per target and scrape, 0 for unlimited")
+	flag.IntVar(&maxSeriesPerCollector, "limits.max-series-per-collector", 0, "Maximum number of series per collector and scrape, 0 for unlimited")
+	flag.StringVar(&seriesOverflow, "limits.overflow", overflowAggregate, "What to do with series over the limits (aggregate, drop)")
+	flag.StringVar(&logLevel, "log.level", "info", "Log level (debug, info, warn, error)")
+	flag.StringVar(&logFormat, "log.format", "logfmt", "Log format (logfmt, json)")
+	flag.DurationVar(&logRepeatInterval, "log.repeat-interval", time.Minute, "Interval to suppress repeated identical errors, 0 to log all")
 	flag.Parse()
 	ParseEnv()
 
-	// Connect to pgbouncer
-	db, err := connect(dataSourceName)
-	if err != nil {
-		log.Fatal("Failed to connect to PgBouncer: ", err)
+	if err := setupLogger(os.Stderr, logLevel, logFormat, logRepeatInterval); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
 
-	// Create new collector
-	collector := NewCollector(db, namespace)
-	defer collector.Close()
+	// Load config and connect to pgbouncer
+	exporter := NewExporter(configFile)
+	if err := exporter.Reload(); err != nil {
+		logger.Error("Failed to load config", "err", err)
+		os.Exit(1)
+	}
+	defer exporter.Close()
 
-	// Register collector
+	// Register reload metrics and the metrics of the exporter process
 	r := prometheus.NewRegistry()
-	r.MustRegister(collector)
-
-	listenAddress := net.JoinHostPort(metricsHost, fmt.Sprint(metricsPort))
-	mux := http.NewServeMux()
-
-	// Add metricsPath
-	mux.Handle(metricsPath, promhttp.HandlerFor(r, promhttp.HandlerOpts{}))
-
-	// Add healthzPath
-	mux.HandleFunc(healthzPath, func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(200)
-		if _, err := w.Write([]byte("ok")); err != nil {
-			log.Fatal("Unable to write to serve metrics: ", err)
+	registerer := prometheus.WrapRegistererWith(exporter.ConstLabels(), r)
+	registerer.MustRegister(
+		exporter,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	// Reload config on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := exporter.Reload(); err != nil {
+				logger.Error("Failed to reload config", "err", err)
+			}
 		}
-	})
+	}()
 
-	// Add index
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		_, err := w.Write([]byte(indexHTML))
-		if err != nil {
-			log.Fatal("Unable to write to serve metrics: ", err)
-		}
-	})
+	web := exporter.Web()
+	mux := newWebMux(exporter, prometheus.Gatherers{r, exporter}, registerer, web)
 
-	err = http.ListenAndServe(listenAddress, mux)
-	log.Fatal("Failed to serve metrics: ", err)
+	err := http.ListenAndServe(web.ListenAddress, mux)
+	logger.Error("Failed to serve metrics", "err", err)
+	os.Exit(1)
 }
 
-func connect(conn string) (*sql.DB, error) {
-	connector, err := pq.NewConnector(conn)
-	if err != nil {
+func connect(target TargetConfig) (*sql.DB, error) {
+	// Validate the connection settings upfront, they are resolved again on every reconnect
+	if _, err := target.buildDSN(); err != nil {
 		return nil, err
 	}
-	db := sql.OpenDB(connector)
+	db := sql.OpenDB(&targetConnector{target: target})
 
 	db.SetMaxOpenConns(1)
 	db.SetMaxIdleConns(1)
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const minSamplerInterval = 100 * time.Millisecond
+
+// sampledPoolColumns are the SHOW POOLS columns summarized between scrapes
+var sampledPoolColumns = []string{"cl_waiting", "sv_active", "maxwait"}
+
+// poolSampler polls SHOW POOLS between scrapes and keeps the min, max and mean of a few columns per pool
+type poolSampler struct {
+	logger      *slog.Logger
+	interval    time.Duration
+	maxPools    int
+	metricGroup *MetricGroup
+	query       func() ([]Row, error)
+
+	mu      sync.Mutex
+	windows map[string]*poolWindow
+	order   []string
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// poolWindow summarizes the samples of a pool since the last scrape
+type poolWindow struct {
+	labelValues []string
+	samples     int
+	counts      []int
+	min         []float64
+	max         []float64
+	sum         []float64
+}
+
+func newPoolSampler(cfg SamplerConfig, logger *slog.Logger, filters []*LabelFilter, query func() ([]Row, error)) *poolSampler {
+	s := &poolSampler{
+		logger:      logger,
+		interval:    cfg.Interval,
+		maxPools:    cfg.MaxPools,
+		metricGroup: attachFilters(buildMetricGroup(MetricDescriptorPoolSamples, nil), filters),
+		query:       query,
+		windows:     make(map[string]*poolWindow),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *poolSampler) run() {
+	defer close(s.done)
+	// A slow admin console drops ticks instead of queueing queries
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.sample()
+		}
+	}
+}
+
+func (s *poolSampler) sample() {
+	rows, err := s.query()
+	if err != nil {
+		s.logger.Warn("Failed to sample pools", "err", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, row := range rows {
+		labelValues := []string{cast2string(row["database"]), cast2string(row["user"]), cast2string(row["pool_mode"])}
+		if !matchRowFilters(s.metricGroup, labelValues) {
+			continue
+		}
+		key := labelValues[0] + "\xff" + labelValues[1] + "\xff" + labelValues[2]
+		w, ok := s.windows[key]
+		if !ok {
+			if len(s.windows) >= s.maxPools {
+				continue
+			}
+			n := len(sampledPoolColumns)
+			w = &poolWindow{labelValues: labelValues, counts: make([]int, n), min: make([]float64, n), max: make([]float64, n), sum: make([]float64, n)}
+			s.windows[key] = w
+			s.order = append(s.order, key)
+		}
+		for i, column := range sampledPoolColumns {
+			// A value that does not parse is no sample of its column, the columns are counted apart
+			v, err := parseFloat64(row[column])
+			if err != nil {
+				continue
+			}
+			if column == "maxwait" {
+				if us, err := parseFloat64(row["maxwait_us"]); err == nil {
+					v += us * 1e-6
+				}
+			}
+			if w.counts[i] == 0 {
+				w.min[i], w.max[i] = v, v
+			} else {
+				w.min[i], w.max[i] = math.Min(w.min[i], v), math.Max(w.max[i], v)
+			}
+			w.sum[i] += v
+			w.counts[i]++
+		}
+		w.samples++
+	}
+}
+
+// flush returns the metrics of the window and starts a new one
+func (s *poolSampler) flush() []prometheus.Metric {
+	s.mu.Lock()
+	windows, order := s.windows, s.order
+	s.windows, s.order = make(map[string]*poolWindow), nil
+	s.mu.Unlock()
+
+	var metrics []prometheus.Metric
+	emit := func(name string, value float64, labelValues []string) {
+		if metricDesc := s.metricGroup.Metrics[name]; metricDesc != nil {
+			metrics = append(metrics, prometheus.MustNewConstMetric(&metricDesc.Desc, metricDesc.Type, value, labelValues...))
+		}
+	}
+	for _, key := range order {
+		w := windows[key]
+		emit("samples", float64(w.samples), w.labelValues)
+		for i, column := range sampledPoolColumns {
+			if w.counts[i] == 0 {
+				continue
+			}
+			emit(sampleMetricName(column, "min"), w.min[i], w.labelValues)
+			emit(sampleMetricName(column, "max"), w.max[i], w.labelValues)
+			emit(sampleMetricName(column, "mean"), w.sum[i]/float64(w.counts[i]), w.labelValues)
+		}
+	}
+	return metrics
+}
+
+// sampleMetricName names the summary of a column, maxwait is in seconds
+func sampleMetricName(column, stat string) string {
+	if column == "maxwait" {
+		return column + "_" + stat + "_" + unitSeconds
+	}
+	return column + "_" + stat
+}
+
+// Close stops the sampling
+func (s *poolSampler) Close() {
+	close(s.stop)
+	<-s.done
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestPoolSamplerCountsColumnsApart(t *testing.T) {
+	var rows []Row
+	s := newPoolSampler(SamplerConfig{Interval: time.Hour, MaxPools: 10}, slog.Default(), nil, func() ([]Row, error) { return rows, nil })
+	defer s.Close()
+
+	pool := func(clWaiting, svActive, maxwait interface{}) Row {
+		return Row{"database": []byte("app"), "user": []byte("app"), "pool_mode": []byte("transaction"),
+			"cl_waiting": clWaiting, "sv_active": svActive, "maxwait": maxwait, "maxwait_us": int64(0)}
+	}
+	for _, rows = range [][]Row{
+		{pool(int64(4), nil, nil)},
+		{pool(nil, int64(2), nil)},
+		{pool(int64(8), int64(6), nil)},
+	} {
+		s.sample()
+	}
+
+	names := make(map[*prometheus.Desc]string)
+	for name, metricDesc := range s.metricGroup.Metrics {
+		names[&metricDesc.Desc] = name
+	}
+	got := map[string]float64{}
+	for _, m := range s.flush() {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		got[names[m.Desc()]] = pb.GetGauge().GetValue()
+	}
+	want := map[string]float64{
+		"samples":        3,
+		"cl_waiting_min": 4, "cl_waiting_max": 8, "cl_waiting_mean": 6,
+		"sv_active_min": 2, "sv_active_max": 6, "sv_active_mean": 4,
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for name, v := range want {
+		if got[name] != v {
+			t.Errorf("%s = %v, want %v", name, got[name], v)
+		}
+	}
+}
+
+func TestPoolSamplerMaxwaitAggregatesWithMax(t *testing.T) {
+	var rows []Row
+	for _, database := range []string{"app1", "app2", "app3"} {
+		rows = append(rows, Row{"database": []byte(database), "user": []byte("app"), "pool_mode": []byte("transaction"),
+			"cl_waiting": int64(1), "sv_active": int64(1), "maxwait": int64(4), "maxwait_us": int64(0)})
+	}
+	s := newPoolSampler(SamplerConfig{Interval: time.Hour, MaxPools: 10}, slog.Default(), nil, func() ([]Row, error) { return rows, nil })
+	defer s.Close()
+	s.sample()
+
+	// One pool of 10 series fits next to the aggregated series of the others
+	guard := newSeriesGuard(LimitsConfig{MaxSeries: 20, Overflow: overflowAggregate},
+		prometheus.NewCounterVec(buildCounterOpts(InternalMetricSeriesDropped), []string{"collector"}))
+	guard.begin()
+	metrics := guard.apply(MetricDescriptorPoolSamples.Prefix, []*MetricGroup{s.metricGroup}, s.flush())
+
+	names := make(map[*prometheus.Desc]string)
+	for name, metricDesc := range s.metricGroup.Metrics {
+		names[&metricDesc.Desc] = name
+	}
+	aggregated := make(map[string]float64)
+	for _, m := range metrics {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		if pb.GetLabel()[0].GetValue() == overflowLabel {
+			aggregated[names[m.Desc()]] = pb.GetGauge().GetValue()
+		}
+	}
+	if aggregated["samples"] != 2 {
+		t.Errorf("aggregated %v samples, want the sum 2", aggregated["samples"])
+	}
+	for _, name := range []string{"maxwait_min_seconds", "maxwait_max_seconds", "maxwait_mean_seconds"} {
+		if v, ok := aggregated[name]; !ok || v != 4 {
+			t.Errorf("aggregated %s = %v, want the max 4", name, v)
+		}
+	}
+}
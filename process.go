@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/procfs"
+)
+
+// ProcessConfig finds the PgBouncer process on the same host by pidfile or by process name
+type ProcessConfig struct {
+	PidFile string `yaml:"pidfile"`
+	Name    string `yaml:"name"`
+}
+
+func (p ProcessConfig) enabled() bool {
+	return len(p.PidFile) != 0 || len(p.Name) != 0
+}
+
+// processCollector reads the resource usage of the PgBouncer process from /proc
+type processCollector struct {
+	config      ProcessConfig
+	metricGroup *MetricGroup
+	fs          procfs.FS
+	fsErr       error
+}
+
+func newProcessCollector(config ProcessConfig) *processCollector {
+	fs, err := procfs.NewDefaultFS()
+	return &processCollector{config: config, metricGroup: buildMetricGroup(MetricDescriptorProcess, nil), fs: fs, fsErr: err}
+}
+
+// find looks up the process once per scrape, the pidfile is followed on every scrape
+func (p *processCollector) find() (procfs.Proc, error) {
+	if p.fsErr != nil {
+		return procfs.Proc{}, p.fsErr
+	}
+	pid, err := p.pid()
+	if err != nil {
+		return procfs.Proc{}, err
+	}
+	return p.fs.Proc(pid)
+}
+
+// pid reads the pidfile or looks up the only process with the name, which walks all of /proc
+func (p *processCollector) pid() (int, error) {
+	if len(p.config.PidFile) != 0 {
+		content, err := os.ReadFile(p.config.PidFile)
+		if err != nil {
+			return 0, err
+		}
+		return strconv.Atoi(strings.TrimSpace(string(content)))
+	}
+
+	procs, err := p.fs.AllProcs()
+	if err != nil {
+		return 0, err
+	}
+	var found []int
+	for _, proc := range procs {
+		if comm, err := proc.Comm(); err == nil && comm == p.config.Name {
+			found = append(found, proc.PID)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return 0, fmt.Errorf("no process named %q", p.config.Name)
+	case 1:
+		return found[0], nil
+	default:
+		return 0, fmt.Errorf("%d processes named %q, set a pidfile", len(found), p.config.Name)
+	}
+}
+
+// metrics returns the metrics of the process that could be read and the first error
+func (p *processCollector) metrics(proc procfs.Proc) ([]prometheus.Metric, error) {
+	var metrics []prometheus.Metric
+	emit := func(name string, value float64) {
+		if metricDesc := p.metricGroup.Metrics[name]; metricDesc != nil {
+			metrics = append(metrics, prometheus.MustNewConstMetric(&metricDesc.Desc, metricDesc.Type, value))
+		}
+	}
+
+	stat, err := proc.Stat()
+	if err != nil {
+		return nil, err
+	}
+	emit("cpu_seconds_total", stat.CPUTime())
+	emit("resident_memory_bytes", float64(stat.ResidentMemory()))
+	emit("virtual_memory_bytes", float64(stat.VirtualMemory()))
+	emit("threads", float64(stat.NumThreads))
+	if start, err := stat.StartTime(); err == nil {
+		emit("start_time_seconds", start)
+	}
+
+	// The fd directory needs the PgBouncer user or CAP_SYS_PTRACE
+	fds, err := proc.FileDescriptorsLen()
+	if err != nil {
+		return metrics, err
+	}
+	emit("open_fds", float64(fds))
+	maxFds, err := p.maxFds(proc)
+	if err != nil {
+		return metrics, err
+	}
+	emit("max_fds", maxFds)
+	return metrics, nil
+}
+
+// startTime returns the start time of the process
+func (p *processCollector) startTime(proc procfs.Proc) (time.Time, error) {
+	stat, err := proc.Stat()
+	if err != nil {
+		return time.Time{}, err
+	}
+	start, err := stat.StartTime()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, int64(start*1e9)), nil
+}
+
+// maxFds returns the open file descriptor limit (RLIMIT_NOFILE) of the process
+func (p *processCollector) maxFds(proc procfs.Proc) (float64, error) {
+	limits, err := proc.Limits()
+	if err != nil {
+		return 0, err
+	}
+	return float64(limits.OpenFiles), nil
+}
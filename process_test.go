@@ -0,0 +1,127 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/procfs"
+)
+
+// newFixtureProcessCollector returns a process collector reading the fixture procfs of testdata/proc,
+// pgbouncer is pid 4242 and postgres pid 4243
+func newFixtureProcessCollector(t *testing.T, config ProcessConfig) *processCollector {
+	t.Helper()
+	fs, err := procfs.NewFS(filepath.Join("testdata", "proc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newProcessCollector(config)
+	p.fs, p.fsErr = fs, nil
+	return p
+}
+
+func TestProcessCollectorFind(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pgbouncer.pid")
+	if err := os.WriteFile(pidFile, []byte("4243\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		config  ProcessConfig
+		want    int
+		wantErr bool
+	}{
+		{config: ProcessConfig{Name: "pgbouncer"}, want: 4242},
+		{config: ProcessConfig{PidFile: pidFile, Name: "pgbouncer"}, want: 4243},
+		{config: ProcessConfig{Name: "odyssey"}, wantErr: true},
+		{config: ProcessConfig{PidFile: filepath.Join(t.TempDir(), "missing.pid")}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		proc, err := newFixtureProcessCollector(t, tt.config).find()
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%+v: found pid %d, want error", tt.config, proc.PID)
+			}
+			continue
+		}
+		if err != nil || proc.PID != tt.want {
+			t.Errorf("%+v: got pid %d, %v, want %d", tt.config, proc.PID, err, tt.want)
+		}
+	}
+}
+
+func TestProcessCollectorRejectsSeveralProcesses(t *testing.T) {
+	root := t.TempDir()
+	for _, pid := range []string{"100", "200"} {
+		if err := os.MkdirAll(filepath.Join(root, pid), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, pid, "comm"), []byte("pgbouncer\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fs, err := procfs.NewFS(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newProcessCollector(ProcessConfig{Name: "pgbouncer"})
+	p.fs, p.fsErr = fs, nil
+	if proc, err := p.find(); err == nil {
+		t.Errorf("found pid %d of two processes, want error", proc.PID)
+	}
+}
+
+func TestProcessCollectorMetrics(t *testing.T) {
+	p := newFixtureProcessCollector(t, ProcessConfig{Name: "pgbouncer"})
+	proc, err := p.find()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// btime of /proc/stat plus 360000 ticks of 100 Hz
+	if start, err := p.startTime(proc); err != nil || !start.Equal(time.Unix(1700003600, 0)) {
+		t.Errorf("got start time %v, %v, want %v", start, err, time.Unix(1700003600, 0))
+	}
+	if maxFds, err := p.maxFds(proc); err != nil || maxFds != 1024 {
+		t.Errorf("got max fds %v, %v, want the soft limit 1024", maxFds, err)
+	}
+
+	metrics, err := p.metrics(proc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[*prometheus.Desc]string)
+	for name, metricDesc := range p.metricGroup.Metrics {
+		names[&metricDesc.Desc] = name
+	}
+	got := make(map[string]float64)
+	for _, m := range metrics {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		got[names[m.Desc()]] = pb.GetGauge().GetValue() + pb.GetCounter().GetValue()
+	}
+	want := map[string]float64{
+		"cpu_seconds_total":     15,
+		"resident_memory_bytes": float64(2560 * os.Getpagesize()),
+		"virtual_memory_bytes":  104857600,
+		"threads":               1,
+		"start_time_seconds":    1700003600,
+		"open_fds":              10,
+		"max_fds":               1024,
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for name, v := range want {
+		if got[name] != v {
+			t.Errorf("%s = %v, want %v", name, got[name], v)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var labelNameRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// RelabelConfig renames, drops and rewrites the labels of a metric group,
+// labels are referenced by their PgBouncer column names
+type RelabelConfig struct {
+	Rename  map[string]string `yaml:"rename"`
+	Drop    []string          `yaml:"drop"`
+	Replace []*LabelReplace   `yaml:"replace"`
+}
+
+// LabelReplace rewrites the values of Label matching Regex with Replacement, $1 refers to capture groups
+type LabelReplace struct {
+	Label       string `yaml:"label"`
+	Regex       string `yaml:"regex"`
+	Replacement string `yaml:"replacement"`
+
+	regex *regexp.Regexp
+}
+
+// validate checks the relabeling of the descriptor, dropping and replacing labels may merge rows and
+// are rejected for counters, as their sum over changing rows would not be monotonic
+func (r *RelabelConfig) validate(descriptor MetricDescriptor) error {
+	labels := descriptor.Labels
+	if r.mergesSeries() {
+		for _, p := range descriptor.MetricProps {
+			if p.Type == prometheus.CounterValue {
+				return fmt.Errorf("drop and replace would merge the counter %q, only rename is supported", p.Name)
+			}
+		}
+	}
+	for source, target := range r.Rename {
+		if !contains(labels, source) {
+			return fmt.Errorf("rename: unknown label %q", source)
+		}
+		if !labelNameRe.MatchString(target) {
+			return fmt.Errorf("rename: invalid label name %q", target)
+		}
+	}
+	for _, label := range r.Drop {
+		if !contains(labels, label) {
+			return fmt.Errorf("drop: unknown label %q", label)
+		}
+	}
+	seen := make(map[string]bool)
+	for _, label := range r.apply(labels) {
+		if seen[label] {
+			return fmt.Errorf("duplicate label %q", label)
+		}
+		seen[label] = true
+	}
+	for _, replace := range r.Replace {
+		if !contains(labels, replace.Label) {
+			return fmt.Errorf("replace: unknown label %q", replace.Label)
+		}
+		var err error
+		if replace.regex, err = regexp.Compile("^(?:" + replace.Regex + ")$"); err != nil {
+			return fmt.Errorf("replace %q: %v", replace.Label, err)
+		}
+	}
+	return nil
+}
+
+// apply returns the label names of the group after renaming and dropping
+func (r *RelabelConfig) apply(labels []string) []string {
+	if r == nil {
+		return labels
+	}
+	var result []string
+	for _, label := range labels {
+		if contains(r.Drop, label) {
+			continue
+		}
+		if target, ok := r.Rename[label]; ok {
+			label = target
+		}
+		result = append(result, label)
+	}
+	return result
+}
+
+// applyValues rewrites label values given in the order of the source labels
+func (r *RelabelConfig) applyValues(labels []string, values []string) []string {
+	if r == nil {
+		return values
+	}
+	var result []string
+	for i, label := range labels {
+		if contains(r.Drop, label) {
+			continue
+		}
+		value := values[i]
+		for _, replace := range r.Replace {
+			if replace.Label == label && replace.regex.MatchString(value) {
+				value = replace.regex.ReplaceAllString(value, replace.Replacement)
+			}
+		}
+		result = append(result, value)
+	}
+	return result
+}
+
+// mergesSeries reports whether relabeling can map different rows to the same series
+func (r *RelabelConfig) mergesSeries() bool {
+	return r != nil && (len(r.Drop) != 0 || len(r.Replace) != 0)
+}
+
+// mergeDuplicates merges the series of the groups with equal label values after relabeling,
+// using the aggregation of the metric like the overflow of the series limits. validate keeps
+// counters out, they are never aggregated.
+func mergeDuplicates(metricGroups []*MetricGroup, metrics []prometheus.Metric) []prometheus.Metric {
+	type series struct {
+		metricDesc  *MetricDesc
+		labelValues []string
+		value       float64
+	}
+	descs := make(map[*prometheus.Desc]*MetricDesc)
+	groups := make(map[*prometheus.Desc]*MetricGroup)
+	for _, metricGroup := range metricGroups {
+		for _, metricDesc := range metricGroup.Metrics {
+			descs[&metricDesc.Desc] = metricDesc
+			groups[&metricDesc.Desc] = metricGroup
+		}
+	}
+
+	var order []string
+	merged := make(map[string]*series)
+	for _, m := range metrics {
+		metricDesc := descs[m.Desc()]
+		var pb dto.Metric
+		if metricDesc == nil || m.Write(&pb) != nil {
+			continue
+		}
+		metricGroup := groups[m.Desc()]
+		values := make(map[string]string, len(pb.Label))
+		for _, l := range pb.Label {
+			values[l.GetName()] = l.GetValue()
+		}
+		labelValues := make([]string, len(metricGroup.OutLabels))
+		for i, label := range metricGroup.OutLabels {
+			labelValues[i] = values[label]
+		}
+
+		key := m.Desc().String() + "\xff" + strings.Join(labelValues, "\xff")
+		value := metricValue(&pb)
+		s, ok := merged[key]
+		if !ok {
+			merged[key] = &series{metricDesc: metricDesc, labelValues: labelValues, value: value}
+			order = append(order, key)
+			continue
+		}
+		s.value = mergeValue(metricDesc.Aggregate, s.value, value)
+	}
+
+	result := make([]prometheus.Metric, 0, len(order))
+	for _, key := range order {
+		s := merged[key]
+		result = append(result, prometheus.MustNewConstMetric(&s.metricDesc.Desc, s.metricDesc.Type, s.value, s.labelValues...))
+	}
+	return result
+}
+
+// mergeValue combines two values of a metric by its aggregation, none keeps the first value
+func mergeValue(aggregate string, a, b float64) float64 {
+	switch aggregate {
+	case aggregateMax:
+		return math.Max(a, b)
+	case aggregateNone:
+		return a
+	default:
+		return a + b
+	}
+}
+
+// constLabelsFlag collects repeated -const-label key=value flags
+type constLabelsFlag map[string]string
+
+func (f constLabelsFlag) String() string {
+	var pairs []string
+	for k, v := range f {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (f constLabelsFlag) Set(value string) error {
+	kv := strings.SplitN(value, "=", 2)
+	if len(kv) != 2 || !labelNameRe.MatchString(kv[0]) {
+		return fmt.Errorf("expected key=value with a valid label name, got %q", value)
+	}
+	f[kv[0]] = kv[1]
+	return nil
+}
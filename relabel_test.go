@@ -0,0 +1,130 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRelabelValidate(t *testing.T) {
+	tests := []struct {
+		name       string
+		descriptor MetricDescriptor
+		relabel    RelabelConfig
+		wantErr    string
+	}{
+		{name: "rename", descriptor: MetricDescriptorDatabases, relabel: RelabelConfig{Rename: map[string]string{"name": "pgbouncer_db"}}},
+		{name: "drop", descriptor: MetricDescriptorDatabases, relabel: RelabelConfig{Drop: []string{"host", "port"}}},
+		{name: "replace", descriptor: MetricDescriptorPools, relabel: RelabelConfig{Replace: []*LabelReplace{{Label: "database", Regex: "(.*)_tenant[0-9]+", Replacement: "$1"}}}},
+		{name: "rename counters", descriptor: MetricDescriptorStats, relabel: RelabelConfig{Rename: map[string]string{"database": "db"}}},
+		{name: "drop counters", descriptor: MetricDescriptorStats, relabel: RelabelConfig{Drop: []string{"database"}}, wantErr: "merge the counter"},
+		{name: "replace counters", descriptor: MetricDescriptorStats, relabel: RelabelConfig{Replace: []*LabelReplace{{Label: "database", Regex: "app.*", Replacement: "app"}}}, wantErr: "merge the counter"},
+		{name: "unknown label", descriptor: MetricDescriptorPools, relabel: RelabelConfig{Drop: []string{"host"}}, wantErr: "unknown label"},
+		{name: "invalid name", descriptor: MetricDescriptorPools, relabel: RelabelConfig{Rename: map[string]string{"user": "user-name"}}, wantErr: "invalid label name"},
+		{name: "duplicate", descriptor: MetricDescriptorPools, relabel: RelabelConfig{Rename: map[string]string{"user": "database"}}, wantErr: "duplicate label"},
+		{name: "invalid regex", descriptor: MetricDescriptorPools, relabel: RelabelConfig{Replace: []*LabelReplace{{Label: "user", Regex: "("}}}, wantErr: "replace"},
+	}
+
+	for _, tt := range tests {
+		err := tt.relabel.validate(tt.descriptor)
+		if len(tt.wantErr) == 0 && err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+		if len(tt.wantErr) != 0 && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestRelabelApply(t *testing.T) {
+	relabel := &RelabelConfig{
+		Rename:  map[string]string{"name": "pgbouncer_db"},
+		Drop:    []string{"host", "port"},
+		Replace: []*LabelReplace{{Label: "name", Regex: "(.*)_tenant[0-9]+", Replacement: "$1"}},
+	}
+	if err := relabel.validate(MetricDescriptorDatabases); err != nil {
+		t.Fatal(err)
+	}
+	labels := MetricDescriptorDatabases.Labels
+
+	want := []string{"pgbouncer_db", "database", "force_user", "pool_mode"}
+	if got := relabel.apply(labels); !reflect.DeepEqual(got, want) {
+		t.Errorf("apply() = %v, want %v", got, want)
+	}
+	tests := []struct {
+		values []string
+		want   []string
+	}{
+		{
+			values: []string{"app_tenant1", "10.0.0.10", "5432", "app_db", "", "transaction"},
+			want:   []string{"app", "app_db", "", "transaction"},
+		},
+		// Values not matching the whole regex are kept
+		{
+			values: []string{"app_tenant1x", "10.0.0.10", "5432", "app_db", "", ""},
+			want:   []string{"app_tenant1x", "app_db", "", ""},
+		},
+	}
+	for _, tt := range tests {
+		if got := relabel.applyValues(labels, tt.values); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("applyValues(%v) = %v, want %v", tt.values, got, tt.want)
+		}
+	}
+
+	var none *RelabelConfig
+	if got := none.apply(labels); !reflect.DeepEqual(got, labels) || none.mergesSeries() {
+		t.Errorf("nil relabel changed the labels to %v", got)
+	}
+}
+
+func TestRelabelMergesPools(t *testing.T) {
+	pgbouncer := &fakePgbouncer{}
+	pgbouncer.set("SHOW POOLS;", []string{"database", "user", "pool_mode", "cl_active", "maxwait", "maxwait_us"}, [][]interface{}{
+		{[]byte("app_tenant1"), []byte("alice"), []byte("transaction"), int64(2), int64(3), int64(0)},
+		{[]byte("app_tenant2"), []byte("alice"), []byte("session"), int64(5), int64(1), int64(0)},
+		{[]byte("other"), []byte("bob"), []byte("transaction"), int64(1), int64(0), int64(0)},
+	})
+	relabel := &RelabelConfig{
+		Drop:    []string{"pool_mode"},
+		Replace: []*LabelReplace{{Label: "database", Regex: "(.*)_tenant[0-9]+", Replacement: "$1"}},
+	}
+	if err := relabel.validate(MetricDescriptorPools); err != nil {
+		t.Fatal(err)
+	}
+	collector := newFakeCollector(t, pgbouncer, TargetConfig{}, &Config{
+		Collectors: []string{"pools"},
+		Relabel:    map[string]*RelabelConfig{"pools": relabel},
+	})
+
+	// The gauges of the merged pools are summed, maxwait takes the maximum
+	body := scrapeOpenMetrics(t, collector)
+	for _, want := range []string{
+		`pgbouncer_pools_cl_active{database="app",user="alice"} 7`,
+		`pgbouncer_pools_maxwait_seconds{database="app",user="alice"} 3`,
+		`pgbouncer_pools_cl_active{database="other",user="bob"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %q in\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "tenant") || strings.Contains(body, "pool_mode") {
+		t.Errorf("relabeled labels exported\n%s", body)
+	}
+}
+
+func TestConstLabelsFlag(t *testing.T) {
+	f := constLabelsFlag{}
+	for _, value := range []string{"cluster=main", "az=eu-1a", "empty=", "url=a=b"} {
+		if err := f.Set(value); err != nil {
+			t.Errorf("Set(%q): %v", value, err)
+		}
+	}
+	if got, want := f.String(), "az=eu-1a,cluster=main,empty=,url=a=b"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	for _, value := range []string{"cluster", "=main", "bad-name=x"} {
+		if err := f.Set(value); err == nil {
+			t.Errorf("Set(%q) accepted an invalid label", value)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// startSourceProcess is the start time of the PgBouncer process
+	startSourceProcess = "process"
+	// startSourceRestart is estimated halfway between the scrapes around a detected restart
+	startSourceRestart = "restart"
+)
+
+// startTracker detects PgBouncer restarts from STATS counters decreasing between scrapes
+type startTracker struct {
+	mu         sync.Mutex
+	counters   map[string]float64
+	lastScrape time.Time
+	startTime  time.Time
+	source     string
+}
+
+func newStartTracker() *startTracker {
+	return &startTracker{counters: make(map[string]float64)}
+}
+
+// observe compares the counters of the STATS rows with the previous scrape and reports a restart,
+// the start time is estimated halfway between the two scrapes
+func (t *startTracker) observe(now time.Time, metricGroup *MetricGroup, rows []Row) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	restarted := false
+	counters := make(map[string]float64, len(t.counters))
+	for _, row := range rows {
+		database := cast2string(row["database"])
+		for column, metricDesc := range metricGroup.Metrics {
+			if metricDesc.Type != prometheus.CounterValue {
+				continue
+			}
+			v, err := parseFloat64(row[column])
+			if err != nil {
+				continue
+			}
+			key := database + "\xff" + column
+			if last, ok := t.counters[key]; ok && v < last {
+				restarted = true
+			}
+			counters[key] = v
+		}
+	}
+
+	// A process start time after the previous scrape already tells the restart
+	if restarted && !(t.source == startSourceProcess && t.startTime.After(t.lastScrape)) {
+		t.startTime = t.lastScrape.Add(now.Sub(t.lastScrape) / 2)
+		t.source = startSourceRestart
+	}
+	t.counters = counters
+	t.lastScrape = now
+	return restarted
+}
+
+// set sets the start time read from the process
+func (t *startTracker) set(start time.Time, source string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.startTime, t.source = start, source
+}
+
+// start returns the start time and its source, zero until it is read from the process or a restart is detected
+func (t *startTracker) start() (time.Time, string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.startTime, t.source
+}
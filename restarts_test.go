@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// statsRows returns a SHOW STATS row of the database with the total query count
+func statsRows(database string, queries int64) []Row {
+	return []Row{{"database": []byte(database), "total_query_count": queries}}
+}
+
+func TestStartTrackerDetectsRestarts(t *testing.T) {
+	stats := buildMetricGroup(MetricDescriptorStats, nil)
+	tracker := newStartTracker()
+	t0 := time.Unix(1700000000, 0)
+
+	// The first scrape and growing counters tell no start time
+	if tracker.observe(t0, stats, statsRows("app", 100)) {
+		t.Error("first scrape detected a restart")
+	}
+	if tracker.observe(t0.Add(time.Minute), stats, statsRows("app", 200)) {
+		t.Error("growing counters detected a restart")
+	}
+	if start, source := tracker.start(); !start.IsZero() || source != "" {
+		t.Errorf("got %v from %q before a restart, want none", start, source)
+	}
+
+	// Counters going down are estimated halfway between the scrapes
+	if !tracker.observe(t0.Add(3*time.Minute), stats, statsRows("app", 10)) {
+		t.Error("counters going down detected no restart")
+	}
+	if start, source := tracker.start(); !start.Equal(t0.Add(2*time.Minute)) || source != startSourceRestart {
+		t.Errorf("got %v from %s, want the restart estimate %v", start, source, t0.Add(2*time.Minute))
+	}
+}
+
+func TestStartTrackerKeepsProcessStartTime(t *testing.T) {
+	stats := buildMetricGroup(MetricDescriptorStats, nil)
+	tracker := newStartTracker()
+	t0 := time.Unix(1700000000, 0)
+
+	processStart := t0.Add(-time.Hour)
+	tracker.set(processStart, startSourceProcess)
+	tracker.observe(t0, stats, statsRows("app", 100))
+	if start, source := tracker.start(); !start.Equal(processStart) || source != startSourceProcess {
+		t.Errorf("got %v from %s, want the process start %v", start, source, processStart)
+	}
+
+	// The restarted process tells its start time, the estimate does not replace it
+	restart := t0.Add(30 * time.Second)
+	tracker.set(restart, startSourceProcess)
+	if !tracker.observe(t0.Add(time.Minute), stats, statsRows("app", 10)) {
+		t.Error("counters going down detected no restart")
+	}
+	if start, source := tracker.start(); !start.Equal(restart) || source != startSourceProcess {
+		t.Errorf("got %v from %s, want the process start %v", start, source, restart)
+	}
+}
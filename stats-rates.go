@@ -0,0 +1,106 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// statsRateColumns are the STATS counters kept between scrapes
+var statsRateColumns = []string{
+	"total_query_count", "total_xact_count", "total_received", "total_sent",
+	"total_query_time", "total_xact_time", "total_wait_time",
+}
+
+// statsRates computes per-second rates and average times from the STATS counters of consecutive scrapes
+type statsRates struct {
+	metricGroup *MetricGroup
+	last        map[string]statsSample
+}
+
+type statsSample struct {
+	time   time.Time
+	values map[string]float64
+}
+
+func newStatsRates(filters []*LabelFilter) *statsRates {
+	return &statsRates{
+		metricGroup: attachFilters(buildMetricGroup(MetricDescriptorStatsRates, nil), filters),
+		last:        make(map[string]statsSample),
+	}
+}
+
+// observe stores the counters of the scrape and returns the rates since the previous one,
+// databases seen for the first time or whose counters went down only start a new interval
+func (r *statsRates) observe(now time.Time, rows []Row) []prometheus.Metric {
+	var metrics []prometheus.Metric
+	emit := func(name string, value float64, database string) {
+		if metricDesc := r.metricGroup.Metrics[name]; metricDesc != nil {
+			metrics = append(metrics, prometheus.MustNewConstMetric(&metricDesc.Desc, metricDesc.Type, value, database))
+		}
+	}
+
+	current := make(map[string]statsSample, len(rows))
+	for _, row := range rows {
+		database := cast2string(row["database"])
+		if !matchRowFilters(r.metricGroup, []string{database}) {
+			continue
+		}
+		sample := statsSample{time: now, values: make(map[string]float64, len(statsRateColumns))}
+		for _, column := range statsRateColumns {
+			if v, err := parseFloat64(row[column]); err == nil {
+				sample.values[column] = v
+			}
+		}
+		current[database] = sample
+
+		last, ok := r.last[database]
+		if !ok {
+			continue
+		}
+		interval := now.Sub(last.time).Seconds()
+		delta, ok := sample.delta(last)
+		if !ok || interval <= 0 {
+			continue
+		}
+
+		// PgBouncer reports times in microseconds, averages need a count that increased
+		average := func(name, timeColumn, countColumn string) {
+			t, ok := delta[timeColumn]
+			if count := delta[countColumn]; ok && count > 0 {
+				emit(name, t*1e-6/count, database)
+			}
+		}
+		rate := func(name, column string) {
+			if d, ok := delta[column]; ok {
+				emit(name, d/interval, database)
+			}
+		}
+		emit("interval_seconds", interval, database)
+		rate("queries_per_second", "total_query_count")
+		rate("xacts_per_second", "total_xact_count")
+		rate("received_bytes_per_second", "total_received")
+		rate("sent_bytes_per_second", "total_sent")
+		average("avg_query_time_seconds", "total_query_time", "total_query_count")
+		average("avg_xact_time_seconds", "total_xact_time", "total_xact_count")
+		average("avg_wait_time_seconds", "total_wait_time", "total_xact_count")
+	}
+	r.last = current
+	return metrics
+}
+
+// delta returns the counter increases since last, false if a counter went down
+func (s statsSample) delta(last statsSample) (map[string]float64, bool) {
+	delta := make(map[string]float64, len(s.values))
+	for column, v := range s.values {
+		previous, ok := last.values[column]
+		if !ok {
+			continue
+		}
+		if v < previous {
+			return nil, false
+		}
+		delta[column] = v - previous
+	}
+	return delta, true
+}
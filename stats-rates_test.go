@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// rateRow returns a SHOW STATS row of the app database
+func rateRow(queries, xacts, received, queryTime, waitTime int64) Row {
+	return Row{
+		"database": []byte("app"), "total_query_count": queries, "total_xact_count": xacts,
+		"total_received": received, "total_sent": received * 2,
+		"total_query_time": queryTime, "total_xact_time": queryTime, "total_wait_time": waitTime,
+	}
+}
+
+// rateValues returns the rates by metric name
+func rateValues(t *testing.T, r *statsRates, metrics []prometheus.Metric) map[string]float64 {
+	t.Helper()
+	names := make(map[*prometheus.Desc]string)
+	for name, metricDesc := range r.metricGroup.Metrics {
+		names[&metricDesc.Desc] = name
+	}
+	values := make(map[string]float64)
+	for _, m := range metrics {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		values[names[m.Desc()]] = pb.GetGauge().GetValue()
+	}
+	return values
+}
+
+func TestStatsRates(t *testing.T) {
+	r := newStatsRates(nil)
+	now := time.Unix(1700000000, 0)
+
+	// The first scrape only starts the interval
+	if metrics := r.observe(now, []Row{rateRow(100, 50, 1000, 200000, 10000)}); len(metrics) != 0 {
+		t.Fatalf("first scrape returned %d rates", len(metrics))
+	}
+
+	now = now.Add(10 * time.Second)
+	got := rateValues(t, r, r.observe(now, []Row{rateRow(300, 100, 6000, 1200000, 60000)}))
+	want := map[string]float64{
+		"interval_seconds":          10,
+		"queries_per_second":        20,
+		"xacts_per_second":          5,
+		"received_bytes_per_second": 500,
+		"sent_bytes_per_second":     1000,
+		"avg_query_time_seconds":    0.005,
+		"avg_xact_time_seconds":     0.02,
+		"avg_wait_time_seconds":     0.001,
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for name, v := range want {
+		if got[name] != v {
+			t.Errorf("%s = %v, want %v", name, got[name], v)
+		}
+	}
+
+	// Counters going down after a restart start a new interval instead of a negative rate
+	now = now.Add(10 * time.Second)
+	if metrics := r.observe(now, []Row{rateRow(5, 2, 100, 1000, 0)}); len(metrics) != 0 {
+		t.Errorf("scrape after a reset returned %d rates", len(metrics))
+	}
+	now = now.Add(5 * time.Second)
+	got = rateValues(t, r, r.observe(now, []Row{rateRow(15, 2, 100, 1000, 0)}))
+	if got["queries_per_second"] != 2 || got["interval_seconds"] != 5 {
+		t.Errorf("got %v after the reset, want 2 queries per second over 5 seconds", got)
+	}
+	// No transactions in the interval leave the averages over them undefined
+	if _, ok := got["avg_xact_time_seconds"]; ok {
+		t.Errorf("got an average transaction time without transactions: %v", got)
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxSyslogMessage is the largest datagram read, longer messages are truncated
+const maxSyslogMessage = 64 * 1024
+
+var (
+	// syslogTimestampRe matches the RFC 3164 timestamp, e.g. "Nov  2 16:04:18 "
+	syslogTimestampRe = regexp.MustCompile(`^[A-Z][a-z]{2} [ 0-9][0-9] [0-9]{2}:[0-9]{2}:[0-9]{2} `)
+	// syslogTagRe matches the optional hostname and the ident[pid]: tag of RFC 3164
+	syslogTagRe = regexp.MustCompile(`^(?:\S+ )?[^\s\[\]:]+\[[0-9]+\]: `)
+	// syslogContentRe matches the start of a PgBouncer message, a level or a connection prefix
+	syslogContentRe = regexp.MustCompile(`^(?:(?:LOG|WARNING|ERROR|FATAL) |[CS]-0x[0-9a-fA-F]+: )`)
+)
+
+// SyslogConfig receives the PgBouncer log as syslog datagrams, an empty listen address disables it
+type SyslogConfig struct {
+	// Listen is udp://host:port or unixgram:///path
+	Listen string `yaml:"listen"`
+	// Forward sends every received datagram on unchanged to another syslog, e.g. unixgram:///dev/log
+	Forward string `yaml:"forward"`
+}
+
+// validate checks the addresses, listen must not be used by another target
+func (s SyslogConfig) validate(listen map[string]bool) error {
+	if len(s.Listen) == 0 {
+		if len(s.Forward) != 0 {
+			return fmt.Errorf("syslog: forward needs listen")
+		}
+		return nil
+	}
+	if listen[s.Listen] {
+		return fmt.Errorf("syslog: listen address %q is used by another target", s.Listen)
+	}
+	listen[s.Listen] = true
+	if _, _, err := parseSyslogAddress(s.Listen); err != nil {
+		return fmt.Errorf("syslog: %v", err)
+	}
+	if len(s.Forward) != 0 {
+		if _, _, err := parseSyslogAddress(s.Forward); err != nil {
+			return fmt.Errorf("syslog: %v", err)
+		}
+		if s.Forward == s.Listen {
+			return fmt.Errorf("syslog: forward to the listen address would loop")
+		}
+	}
+	return nil
+}
+
+// parseSyslogAddress splits udp://host:port and unixgram:///path into network and address
+func parseSyslogAddress(addr string) (string, string, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", "", err
+	}
+	switch u.Scheme {
+	case "udp", "udp4", "udp6":
+		if len(u.Host) == 0 {
+			return "", "", fmt.Errorf("syslog address %q: host:port is required", addr)
+		}
+		return u.Scheme, u.Host, nil
+	case "unixgram":
+		if len(u.Path) == 0 {
+			return "", "", fmt.Errorf("syslog address %q: path is required", addr)
+		}
+		return u.Scheme, u.Path, nil
+	default:
+		return "", "", fmt.Errorf("syslog address %q: expected udp://host:port or unixgram:///path", addr)
+	}
+}
+
+// syslogReceiver reads syslog datagrams and passes the PgBouncer log lines to observe,
+// it is kept across config reloads as long as the listen address stays the same
+type syslogReceiver struct {
+	logger *slog.Logger
+	conn   net.PacketConn
+	// path of the unix socket, removed on close
+	path string
+
+	mu      sync.Mutex
+	observe func(line string)
+	forward string
+	out     net.Conn
+
+	done chan struct{}
+}
+
+func newSyslogReceiver(listen string, logger *slog.Logger) (*syslogReceiver, error) {
+	network, address, err := parseSyslogAddress(listen)
+	if err != nil {
+		return nil, err
+	}
+	r := &syslogReceiver{logger: logger.With("listen", listen), done: make(chan struct{})}
+	if network == "unixgram" {
+		// A socket left behind by an exporter that was killed blocks the bind
+		if info, err := os.Stat(address); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(address)
+		}
+		r.path = address
+	}
+	if r.conn, err = net.ListenPacket(network, address); err != nil {
+		return nil, err
+	}
+	go r.run()
+	return r, nil
+}
+
+// update sets the counters of the current collector and the forward address
+func (r *syslogReceiver) update(forward string, observe func(line string)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.observe = observe
+	if forward != r.forward {
+		r.closeForward()
+		r.forward = forward
+	}
+}
+
+func (r *syslogReceiver) run() {
+	defer close(r.done)
+	buf := make([]byte, maxSyslogMessage)
+	for {
+		n, _, err := r.conn.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				r.logger.Error("Failed to receive syslog message", "err", err)
+			}
+			return
+		}
+		r.receive(buf[:n])
+	}
+}
+
+func (r *syslogReceiver) receive(msg []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.forward) != 0 {
+		if err := r.send(msg); err != nil {
+			r.logger.Warn("Failed to forward syslog message", "forward", r.forward, "err", err)
+			r.closeForward()
+		}
+	}
+	if r.observe == nil {
+		return
+	}
+	for _, line := range strings.Split(strings.TrimRight(string(msg), "\x00\r\n"), "\n") {
+		if line, ok := parseSyslogMessage(line); ok {
+			r.observe(line)
+		}
+	}
+}
+
+// send writes the datagram to the forward address, connecting again after a failure
+func (r *syslogReceiver) send(msg []byte) error {
+	if r.out == nil {
+		network, address, err := parseSyslogAddress(r.forward)
+		if err != nil {
+			return err
+		}
+		if r.out, err = net.Dial(network, address); err != nil {
+			return err
+		}
+	}
+	_, err := r.out.Write(msg)
+	return err
+}
+
+func (r *syslogReceiver) closeForward() {
+	if r.out != nil {
+		r.out.Close()
+		r.out = nil
+	}
+}
+
+// Close stops receiving and removes the unix socket
+func (r *syslogReceiver) Close() {
+	r.conn.Close()
+	<-r.done
+	r.mu.Lock()
+	r.closeForward()
+	r.mu.Unlock()
+	if len(r.path) != 0 {
+		os.Remove(r.path)
+	}
+}
+
+// parseSyslogMessage strips the RFC 3164 or RFC 5424 header of a message, a message without
+// PgBouncer level gets the level of its syslog severity
+func parseSyslogMessage(msg string) (string, bool) {
+	if !strings.HasPrefix(msg, "<") {
+		return "", false
+	}
+	end := strings.IndexByte(msg, '>')
+	if end < 0 {
+		return "", false
+	}
+	pri, err := strconv.Atoi(msg[1:end])
+	if err != nil || pri < 0 || pri > 191 {
+		return "", false
+	}
+	msg = msg[end+1:]
+
+	if strings.HasPrefix(msg, "1 ") {
+		// VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+		fields := strings.SplitN(msg, " ", 7)
+		if len(fields) < 7 {
+			return "", false
+		}
+		msg = fields[6]
+		if strings.HasPrefix(msg, "[") {
+			if i := strings.Index(msg, "] "); i >= 0 {
+				msg = msg[i+2:]
+			}
+		} else {
+			msg = strings.TrimPrefix(msg, "- ")
+		}
+		msg = strings.TrimPrefix(msg, "\ufeff")
+	} else {
+		// TIMESTAMP [HOSTNAME] [TAG[PID]:] MSG, a message without tag keeps its C-0x... prefix
+		msg = strings.TrimPrefix(msg, syslogTimestampRe.FindString(msg))
+		if tag := syslogTagRe.FindString(msg); len(tag) != 0 {
+			msg = msg[len(tag):]
+		} else if _, rest, ok := strings.Cut(msg, " "); ok && !syslogContentRe.MatchString(msg) && syslogContentRe.MatchString(rest) {
+			// The hostname before the message
+			msg = rest
+		}
+	}
+
+	if !logLevelRe.MatchString(msg) {
+		msg = syslogLevel(pri%8) + " " + msg
+	}
+	return msg, true
+}
+
+// syslogLevel maps a syslog severity to the PgBouncer level
+func syslogLevel(severity int) string {
+	switch {
+	case severity <= 3:
+		return "ERROR"
+	case severity == 4:
+		return "WARNING"
+	default:
+		return "LOG"
+	}
+}
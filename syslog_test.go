@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+func TestParseSyslogMessage(t *testing.T) {
+	const closing = "C-0x7f9c3a8e27a0: app/bob@172.18.0.6:45900 closing because: client_idle_timeout (age=300s)"
+	tests := []struct {
+		msg  string
+		want string
+		ok   bool
+	}{
+		// RFC 3164 with hostname and ident[pid] tag
+		{msg: "<30>Nov 20 16:04:18 db1 pgbouncer[812]: LOG " + closing, want: "LOG " + closing, ok: true},
+		{msg: "<30>Nov  2 16:04:18 pgbouncer[812]: " + closing, want: "LOG " + closing, ok: true},
+		// RFC 3164 without tag, the connection prefix is kept
+		{msg: "<30>Nov 20 16:04:18 " + closing, want: "LOG " + closing, ok: true},
+		{msg: "<30>Nov 20 16:04:18 db1 " + closing, want: "LOG " + closing, ok: true},
+		{msg: "<28>Nov 20 16:04:18 db1 WARNING " + closing, want: "WARNING " + closing, ok: true},
+		{msg: "<30>" + closing, want: "LOG " + closing, ok: true},
+		// RFC 5424 with and without tag and structured data
+		{msg: "<30>1 2023-11-20T16:04:18.920Z db1 pgbouncer 812 - - LOG " + closing, want: "LOG " + closing, ok: true},
+		{msg: "<30>1 2023-11-20T16:04:18.920Z db1 - - - - " + closing, want: "LOG " + closing, ok: true},
+		{msg: "<30>1 2023-11-20T16:04:18.920Z db1 pgbouncer 812 - [origin ip=\"10.0.0.1\"] \ufeffLOG " + closing, want: "LOG " + closing, ok: true},
+		// The severity is the level of a message without PgBouncer level
+		{msg: "<24>Nov 20 16:04:18 db1 pgbouncer[812]: " + closing, want: "ERROR " + closing, ok: true},
+		{msg: "<27>Nov 20 16:04:18 db1 pgbouncer[812]: " + closing, want: "ERROR " + closing, ok: true},
+		{msg: "<28>Nov 20 16:04:18 db1 pgbouncer[812]: " + closing, want: "WARNING " + closing, ok: true},
+		{msg: "<29>Nov 20 16:04:18 db1 pgbouncer[812]: " + closing, want: "LOG " + closing, ok: true},
+		{msg: "<191>1 2023-11-20T16:04:18Z db1 pgbouncer 812 - - " + closing, want: "LOG " + closing, ok: true},
+		{msg: "<26>1 2023-11-20T16:04:18Z db1 pgbouncer 812 - - " + closing, want: "ERROR " + closing, ok: true},
+		// The PgBouncer level wins over the severity
+		{msg: "<30>Nov 20 16:04:18 db1 pgbouncer[812]: WARNING " + closing, want: "WARNING " + closing, ok: true},
+		{msg: "no priority"},
+		{msg: "<30 no end"},
+		{msg: "<192>Nov 20 16:04:18 db1 pgbouncer[812]: LOG " + closing},
+		{msg: "<30>1 2023-11-20T16:04:18Z db1"},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseSyslogMessage(tt.msg)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseSyslogMessage(%q) = %q, %v, want %q, %v", tt.msg, got, ok, tt.want, tt.ok)
+		}
+	}
+}
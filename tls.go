@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// addressDialer dials the configured host, while the connection options carry the TLS server name
+type addressDialer struct {
+	host   string
+	dialer net.Dialer
+}
+
+func (d *addressDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+func (d *addressDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return d.DialContext(ctx, network, address)
+}
+
+func (d *addressDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if _, port, err := net.SplitHostPort(address); err == nil {
+		address = net.JoinHostPort(d.host, port)
+	}
+	return d.dialer.DialContext(ctx, network, address)
+}
+
+// certExpiry caches the expiry time of a certificate file until the file is modified
+type certExpiry struct {
+	path    string
+	modTime time.Time
+	size    int64
+	expiry  time.Time
+}
+
+// read returns the expiry time, the file is only parsed again after a change of its mtime or size
+func (c *certExpiry) read() (time.Time, error) {
+	info, err := os.Stat(c.path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !c.expiry.IsZero() && info.ModTime().Equal(c.modTime) && info.Size() == c.size {
+		return c.expiry, nil
+	}
+	expiry, err := readCertExpiry(c.path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	c.modTime, c.size, c.expiry = info.ModTime(), info.Size(), expiry
+	return expiry, nil
+}
+
+// readCertExpiry returns the expiry time of the first certificate in a PEM file
+func readCertExpiry(path string) (time.Time, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for {
+		var block *pem.Block
+		block, content = pem.Decode(content)
+		if block == nil {
+			return time.Time{}, fmt.Errorf("no certificate found in %s", path)
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return cert.NotAfter, nil
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed PEM certificate expiring at notAfter
+func writeCert(t *testing.T, path string, notAfter time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "stats"},
+		NotBefore:    notAfter.Add(-24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCertExpiryCachedByModTime(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "client.crt")
+	first := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	writeCert(t, path, first)
+	modTime := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	cache := &certExpiry{path: path}
+	if got, err := cache.read(); err != nil || !got.Equal(first) {
+		t.Fatalf("got %v, %v, want %v", got, err, first)
+	}
+
+	// Same mtime and size: the cached expiry is returned without parsing
+	second := time.Date(2031, 1, 1, 0, 0, 0, 0, time.UTC)
+	writeCert(t, path, second)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(path); info.Size() == cache.size {
+		if got, err := cache.read(); err != nil || !got.Equal(first) {
+			t.Errorf("unchanged mtime: got %v, %v, want cached %v", got, err, first)
+		}
+	}
+
+	// A rotated certificate is read again
+	if err := os.Chtimes(path, time.Now(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := cache.read(); err != nil || !got.Equal(second) {
+		t.Errorf("rotated: got %v, %v, want %v", got, err, second)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.read(); err == nil {
+		t.Error("removed certificate: want error")
+	}
+}
@@ -1,46 +1,104 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"math"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 )
 
-// cast2Float64 cast database driver interface{} to float64
+// cast2Float64 cast database driver interface{} to float64, NaN if it cannot be converted
 func cast2Float64(t interface{}, factor float64) float64 {
+	v, err := parseFloat64(t)
+	if err != nil {
+		return math.NaN()
+	}
+	return v * factor
+}
+
+// parseFloat64 converts database driver interface{} to float64,
+// strings may carry a PgBouncer duration (converted to seconds) or size (converted to bytes) unit
+func parseFloat64(t interface{}) (float64, error) {
+	v, _, err := parseValue(t)
+	return v, err
+}
+
+// parseValue converts database driver interface{} to float64 and reports whether it had a unit,
+// NaN and infinite values are errors
+func parseValue(t interface{}) (float64, bool, error) {
 	switch v := t.(type) {
 	case int64:
-		return float64(v) * factor
+		return float64(v), false, nil
 	case float64:
-		return v * factor
+		return finite(v, false)
 	case time.Time:
-		return float64(v.Unix())
+		return float64(v.Unix()), true, nil
 	case time.Duration:
-		return float64(v.Nanoseconds())
+		return v.Seconds(), true, nil
 	case []byte:
-		strV := string(v)
-		result, err := strconv.ParseFloat(strV, 64)
-		if err != nil {
-			return math.NaN()
-		}
-		return result * factor
+		return parseString(string(v))
 	case string:
-		result, err := strconv.ParseFloat(v, 64)
-		if err != nil {
-			return math.NaN()
-		}
-		return result * factor
+		return parseString(v)
 	case bool:
 		if v {
-			return 1.0
+			return 1.0, false, nil
 		}
-		return 0.0
+		return 0.0, false, nil
 	case nil:
-		return math.NaN()
+		return 0, false, errors.New("null value")
 	default:
-		return math.NaN()
+		return 0, false, fmt.Errorf("unsupported type %T", t)
+	}
+}
+
+var valueWithUnitRe = regexp.MustCompile(`^\s*([-+]?(?:[0-9]+\.?[0-9]*|\.[0-9]+)(?:[eE][-+]?[0-9]+)?)\s*([a-zA-Z]+)\s*$`)
+
+var unitFactors = map[string]float64{
+	"us":  1e-6,
+	"ms":  1e-3,
+	"s":   1,
+	"min": 60,
+	"h":   60 * 60,
+	"d":   24 * 60 * 60,
+	"B":   1,
+	"kB":  1 << 10,
+	"KB":  1 << 10,
+	"MB":  1 << 20,
+	"GB":  1 << 30,
+	"TB":  1 << 40,
+}
+
+// finite passes v on unless it is NaN or infinite
+func finite(v float64, hasUnit bool) (float64, bool, error) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, false, fmt.Errorf("non-finite number %v", v)
 	}
+	return v, hasUnit, nil
+}
+
+func parseString(s string) (float64, bool, error) {
+	if v, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+		return finite(v, false)
+	}
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "on", "yes", "true":
+		return 1, false, nil
+	case "off", "no", "false":
+		return 0, false, nil
+	}
+	if m := valueWithUnitRe.FindStringSubmatch(s); m != nil {
+		if factor, ok := unitFactors[m[2]]; ok {
+			v, err := strconv.ParseFloat(m[1], 64)
+			if err != nil {
+				return 0, false, err
+			}
+			return finite(v*factor, true)
+		}
+	}
+	return 0, false, fmt.Errorf("invalid number %q", s)
 }
 
 // cast2Float64 cast database driver interface{} to string
@@ -68,6 +126,16 @@ func cast2string(t interface{}) string {
 	}
 }
 
+// indexOf returns the index of x in arr, -1 if it is missing
+func indexOf(arr []string, x string) int {
+	for i, v := range arr {
+		if v == x {
+			return i
+		}
+	}
+	return -1
+}
+
 func contains(arr []string, x string) bool {
 	for _, n := range arr {
 		if x == n {
@@ -75,4 +143,16 @@ func contains(arr []string, x string) bool {
 		}
 	}
 	return false
-}
\ No newline at end of file
+}
+
+// listFlag collects the values of a repeated flag
+type listFlag []string
+
+func (f *listFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *listFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
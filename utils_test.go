@@ -0,0 +1,99 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestParseValue(t *testing.T) {
+	tests := []struct {
+		value   interface{}
+		want    float64
+		hasUnit bool
+		wantErr bool
+	}{
+		{value: int64(42), want: 42},
+		{value: 2.5, want: 2.5},
+		{value: []byte("17"), want: 17},
+		{value: " -3.5e2 ", want: -350},
+		{value: ".5", want: 0.5},
+		{value: true, want: 1},
+		{value: false, want: 0},
+		{value: []byte("on"), want: 1},
+		{value: "YES", want: 1},
+		{value: "off", want: 0},
+		{value: "false", want: 0},
+		{value: time.Unix(1700000000, 0), want: 1700000000, hasUnit: true},
+		{value: 1500 * time.Millisecond, want: 1.5, hasUnit: true},
+		{value: []byte("500ms"), want: 0.5, hasUnit: true},
+		{value: "120000000us", want: 120, hasUnit: true},
+		{value: "15 s", want: 15, hasUnit: true},
+		{value: "60min", want: 3600, hasUnit: true},
+		{value: "1h", want: 3600, hasUnit: true},
+		{value: "1d", want: 86400, hasUnit: true},
+		{value: "4kB", want: 4096, hasUnit: true},
+		{value: "1.5MB", want: 1.5 * (1 << 20), hasUnit: true},
+		{value: "2GB", want: 2 << 30, hasUnit: true},
+		{value: nil, wantErr: true},
+		{value: "", wantErr: true},
+		{value: "garbage", wantErr: true},
+		{value: "12 parsecs", wantErr: true},
+		{value: "1.2.3", wantErr: true},
+		{value: "5 m", wantErr: true},
+		{value: []int{1}, wantErr: true},
+		{value: "NaN", wantErr: true},
+		{value: "nan", wantErr: true},
+		{value: "Inf", wantErr: true},
+		{value: "+Inf", wantErr: true},
+		{value: "-Infinity", wantErr: true},
+		{value: "1e400", wantErr: true},
+		{value: "1e308TB", wantErr: true},
+		{value: math.NaN(), wantErr: true},
+		{value: math.Inf(-1), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, hasUnit, err := parseValue(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseValue(%#v) = %v, want error", tt.value, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseValue(%#v): %v", tt.value, err)
+			continue
+		}
+		if math.Abs(got-tt.want) > 1e-9 || hasUnit != tt.hasUnit {
+			t.Errorf("parseValue(%#v) = %v, %v, want %v, %v", tt.value, got, hasUnit, tt.want, tt.hasUnit)
+		}
+	}
+}
+
+func TestMetricGroupValueCountsParseErrors(t *testing.T) {
+	parseErrors := prometheus.NewCounterVec(buildCounterOpts(InternalMetricValueParseErrors), []string{"collector", "column"})
+	metricGroup := buildMetricGroup(MetricDescriptorPools, nil)
+	metricGroup.ParseErrors = parseErrors.MustCurryWith(prometheus.Labels{"collector": "pools"})
+
+	for _, value := range []interface{}{nil, "NaN", "-Infinity", "garbage"} {
+		if v, ok := metricGroup.value("cl_active", value, 1); ok {
+			t.Errorf("value(%#v) = %v, want skipped", value, v)
+		}
+	}
+	metricGroup.Values = ValueConfig{OnParseError: parseErrorDefault, Default: -1}
+	if v, ok := metricGroup.value("cl_active", "Inf", 1); !ok || v != -1 {
+		t.Errorf("value(Inf) = %v, %v, want the default -1", v, ok)
+	}
+
+	var pb dto.Metric
+	if err := parseErrors.WithLabelValues("pools", "cl_active").Write(&pb); err != nil {
+		t.Fatal(err)
+	}
+	if got := pb.GetCounter().GetValue(); got != 5 {
+		t.Errorf("counted %v parse errors, want 5", got)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// handlerInstrumenter adds request duration and in-flight metrics by handler to the HTTP handlers of the exporter
+type handlerInstrumenter struct {
+	duration *prometheus.HistogramVec
+	inFlight *prometheus.GaugeVec
+}
+
+func newHandlerInstrumenter(reg prometheus.Registerer) *handlerInstrumenter {
+	i := &handlerInstrumenter{
+		duration: prometheus.NewHistogramVec(buildHistogramOpts(InternalMetricHTTPRequestDuration), []string{"handler", "code", "method"}),
+		inFlight: prometheus.NewGaugeVec(buildGaugeOpts(InternalMetricHTTPRequestsInFlight), []string{"handler"}),
+	}
+	reg.MustRegister(i.duration, i.inFlight)
+	return i
+}
+
+func (i *handlerInstrumenter) wrap(name string, handler http.Handler) http.Handler {
+	duration := i.duration.MustCurryWith(prometheus.Labels{"handler": name})
+	return promhttp.InstrumentHandlerInFlight(i.inFlight.WithLabelValues(name),
+		promhttp.InstrumentHandlerDuration(duration, handler))
+}
+
+// newWebMux serves the metrics of the gatherer and the health, reload and index pages,
+// instrumented by handler in the registerer
+func newWebMux(exporter *Exporter, gatherer prometheus.Gatherer, registerer prometheus.Registerer, web WebConfig) *http.ServeMux {
+	mux := http.NewServeMux()
+	instrumenter := newHandlerInstrumenter(registerer)
+
+	// Add metricsPath
+	mux.Handle(web.MetricsPath, instrumenter.wrap("metrics",
+		metricsHandler(gatherer, registerer, web.MaxRequests)))
+
+	// Add healthzPath
+	mux.Handle(healthzPath, instrumenter.wrap("healthz", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		if _, err := w.Write([]byte("ok")); err != nil {
+			logger.Error("Unable to write response", "err", err)
+		}
+	})))
+
+	// Add reloadPath
+	mux.Handle(reloadPath, instrumenter.wrap("reload", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if err := exporter.Reload(); err != nil {
+			logger.Error("Failed to reload config", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})))
+
+	// Add index
+	mux.Handle("/", instrumenter.wrap("index", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := fmt.Fprintf(w, indexHTML, web.MetricsPath)
+		if err != nil {
+			logger.Error("Unable to write response", "err", err)
+		}
+	})))
+	return mux
+}
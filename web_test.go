@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// blockingCollector blocks the scrapes until release is closed, started receives each scrape
+type blockingCollector struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (c blockingCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (c blockingCollector) Collect(chan<- prometheus.Metric) {
+	c.started <- struct{}{}
+	<-c.release
+}
+
+// serve returns the status code and body of a request to the handler
+func serve(handler http.Handler, method, path string) (int, string) {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+	return rec.Code, rec.Body.String()
+}
+
+func TestWebMaxRequests(t *testing.T) {
+	defer func(ns string) { namespace = ns }(namespace)
+	namespace = "pgbouncer"
+
+	blocking := blockingCollector{started: make(chan struct{}), release: make(chan struct{})}
+	gatherer := prometheus.NewRegistry()
+	gatherer.MustRegister(blocking)
+	r := prometheus.NewRegistry()
+	mux := newWebMux(NewExporter(""), prometheus.Gatherers{r, gatherer}, r, WebConfig{MetricsPath: metricsPath, MaxRequests: 1})
+
+	// A scrape over the limit is rejected while the first one is running
+	done := make(chan int)
+	go func() {
+		code, _ := serve(mux, http.MethodGet, metricsPath)
+		done <- code
+	}()
+	<-blocking.started
+	if code, body := serve(mux, http.MethodGet, metricsPath); code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d over the limit, want 503: %s", code, body)
+	}
+	close(blocking.release)
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("got status %d of the first scrape, want 200", code)
+	}
+
+	go func() { <-blocking.started }()
+	code, body := serve(mux, http.MethodGet, metricsPath)
+	if code != http.StatusOK {
+		t.Fatalf("got status %d, want 200: %s", code, body)
+	}
+	for _, want := range []string{
+		`promhttp_metric_handler_requests_total{code="503"} 1`,
+		`promhttp_metric_handler_requests_total{code="200"} 1`,
+		`pgbouncer_exporter_http_request_duration_seconds_count{code="503",handler="metrics",method="get"} 1`,
+		`pgbouncer_exporter_http_requests_in_flight{handler="metrics"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %q in\n%s", want, body)
+		}
+	}
+}
+
+func TestWebHandlers(t *testing.T) {
+	defer func(ns string) { namespace = ns }(namespace)
+	namespace = "pgbouncer"
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	// The flags that default the config are not parsed in tests
+	config := "limits: {overflow: aggregate}\nvalues: {on_parse_error: skip}\ntargets:\n  - socket_dir: /nonexistent\n"
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	exporter := NewExporter(path)
+	defer exporter.Close()
+	r := prometheus.NewRegistry()
+	mux := newWebMux(exporter, r, r, WebConfig{MetricsPath: "/custom-metrics"})
+
+	tests := []struct {
+		method, path string
+		code         int
+		body         string
+	}{
+		{method: http.MethodGet, path: healthzPath, code: http.StatusOK, body: "ok"},
+		{method: http.MethodGet, path: "/", code: http.StatusOK, body: "<a href='/custom-metrics'>metrics</a>"},
+		{method: http.MethodGet, path: "/custom-metrics", code: http.StatusOK, body: `pgbouncer_exporter_http_requests_in_flight{handler="metrics"} 1`},
+		{method: http.MethodGet, path: reloadPath, code: http.StatusMethodNotAllowed},
+		{method: http.MethodPost, path: reloadPath, code: http.StatusOK},
+	}
+	for _, tt := range tests {
+		code, body := serve(mux, tt.method, tt.path)
+		if code != tt.code || !strings.Contains(body, tt.body) {
+			t.Errorf("%s %s: got %d %q, want %d with %q", tt.method, tt.path, code, body, tt.code, tt.body)
+		}
+	}
+
+	// A failed reload is reported
+	if err := os.WriteFile(path, []byte("targets: ["), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := serve(mux, http.MethodPost, reloadPath); code != http.StatusInternalServerError {
+		t.Errorf("got status %d of a failed reload, want 500", code)
+	}
+}